@@ -7,4 +7,5 @@ require (
 	github.com/prometheus/client_model v0.0.0-20190129233127-fd36f4220a90
 	github.com/prometheus/common v0.4.1 // indirect
 	github.com/prometheus/prom2json v1.2.1
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,153 @@
+package mpminio
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// prometheusJWTExpiry is short since a fresh token is generated on every run
+const prometheusJWTExpiry = time.Hour
+
+// bearerTransport attaches a bearer token to every request sent through the base transport
+type bearerTransport struct {
+	token string
+	base  http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrip must not modify the given request
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set("Authorization", "Bearer "+t.token)
+	return t.base.RoundTrip(r)
+}
+
+// CloseIdleConnections closes the idle connections of the base transport
+func (t *bearerTransport) CloseIdleConnections() {
+	if c, ok := t.base.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// transport builds the round tripper used for every request to Minio server
+func (m MinioPlugin) transport() (http.RoundTripper, error) {
+	tlsConfig, err := m.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	var transport http.RoundTripper = &http.Transport{
+		TLSClientConfig: tlsConfig,
+	}
+
+	token, err := m.bearerToken()
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		transport = &bearerTransport{token: token, base: transport}
+	}
+
+	return transport, nil
+}
+
+// bearerToken returns the token to authenticate with, preferring the token file.
+// Without any given token, it is generated from the access and secret keys if available.
+func (m MinioPlugin) bearerToken() (string, error) {
+	if m.BearerTokenFile != "" {
+		b, err := ioutil.ReadFile(m.BearerTokenFile)
+		if err != nil {
+			return "", fmt.Errorf("reading bearer token file failed: %v", err)
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	if m.BearerToken != "" {
+		return m.BearerToken, nil
+	}
+	if m.AccessKey != "" && m.SecretKey != "" {
+		return prometheusJWT(m.AccessKey, m.SecretKey, time.Now())
+	}
+	return "", nil
+}
+
+// prometheusJWT generates the same token as `mc admin prometheus generate`:
+// HS512 signed with the secret key, issued by "prometheus" for the access key.
+func prometheusJWT(accessKey, secretKey string, now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "HS512", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"exp": now.Add(prometheusJWTExpiry).Unix(),
+		"iss": "prometheus",
+		"sub": accessKey,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	mac := hmac.New(sha512.New, []byte(secretKey))
+	mac.Write([]byte(unsigned))
+
+	return unsigned + "." + enc.EncodeToString(mac.Sum(nil)), nil
+}
+
+// credentialsFile is the format of the credentials downloaded from MinIO Console
+// or printed by `mc admin user svcacct add --json`
+type credentialsFile struct {
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"secretKey"`
+}
+
+// loadCredentials resolves the access and secret keys. Explicit flags win over the
+// credentials file, which in turn wins over the environment of the MinIO server.
+func loadCredentials(accessKey, secretKey, file string) (string, string, error) {
+	if file != "" {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", "", fmt.Errorf("reading credentials file failed: %v", err)
+		}
+		var c credentialsFile
+		if err := json.Unmarshal(b, &c); err != nil {
+			return "", "", fmt.Errorf("parsing credentials file failed: %v", err)
+		}
+		if accessKey == "" {
+			accessKey = c.AccessKey
+		}
+		if secretKey == "" {
+			secretKey = c.SecretKey
+		}
+	}
+
+	if accessKey == "" {
+		accessKey = firstEnv("MINIO_ACCESS_KEY", "MINIO_ROOT_USER")
+	}
+	if secretKey == "" {
+		secretKey = firstEnv("MINIO_SECRET_KEY", "MINIO_ROOT_PASSWORD")
+	}
+	return accessKey, secretKey, nil
+}
+
+// firstEnv returns the first non-empty value of the given environment variables
+func firstEnv(keys ...string) string {
+	for _, k := range keys {
+		if v := os.Getenv(k); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,124 @@
+package mpminio
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBearerToken(t *testing.T) {
+	s := SetupMockServer(t)
+	defer s.Server.Close()
+	s.token = "secret"
+
+	_, err := s.plugin.FetchMetrics()
+	if errs, ok := err.(errorList); !ok || !isAuthError(errs[0]) {
+		t.Fatalf("got=%v, want an authentication error", err)
+	}
+	if !strings.Contains(err.Error(), "bearer token") {
+		t.Fatalf("error does not explain how to fix it: %v", err)
+	}
+
+	p := s.plugin
+	p.BearerToken = "secret"
+	if _, err := p.FetchMetrics(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ioutil.TempFile("", "mackerel-plugin-minio-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("secret\n")
+	f.Close()
+
+	p = s.plugin
+	p.BearerToken = "stale"
+	p.BearerTokenFile = f.Name()
+	if _, err := p.FetchMetrics(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPrometheusJWT(t *testing.T) {
+	now := time.Unix(1562480101, 0)
+	token, err := prometheusJWT("minioadmin", "minio123", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("got=%d parts, want=3", len(parts))
+	}
+
+	enc := base64.RawURLEncoding
+	mac := hmac.New(sha512.New, []byte("minio123"))
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if enc.EncodeToString(mac.Sum(nil)) != parts[2] {
+		t.Fatal("signature does not match")
+	}
+
+	var header map[string]string
+	b, _ := enc.DecodeString(parts[0])
+	if err := json.Unmarshal(b, &header); err != nil {
+		t.Fatal(err)
+	}
+	if header["alg"] != "HS512" {
+		t.Fatalf("got=%s, want=HS512", header["alg"])
+	}
+
+	var claims struct {
+		Exp int64  `json:"exp"`
+		Iss string `json:"iss"`
+		Sub string `json:"sub"`
+	}
+	b, _ = enc.DecodeString(parts[1])
+	if err := json.Unmarshal(b, &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims.Iss != "prometheus" || claims.Sub != "minioadmin" || claims.Exp != now.Add(prometheusJWTExpiry).Unix() {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+}
+
+func TestLoadCredentials(t *testing.T) {
+	f, err := ioutil.TempFile("", "mackerel-plugin-minio-credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"url":"http://localhost:9000","accessKey":"fileaccess","secretKey":"filesecret","api":"s3v4","path":"auto"}`)
+	f.Close()
+
+	os.Setenv("MINIO_ROOT_USER", "envaccess")
+	os.Setenv("MINIO_ROOT_PASSWORD", "envsecret")
+	defer os.Unsetenv("MINIO_ROOT_USER")
+	defer os.Unsetenv("MINIO_ROOT_PASSWORD")
+
+	tests := []struct {
+		access, secret, file string
+		wantAccess           string
+		wantSecret           string
+	}{
+		{"", "", "", "envaccess", "envsecret"},
+		{"", "", f.Name(), "fileaccess", "filesecret"},
+		{"flagaccess", "", f.Name(), "flagaccess", "filesecret"},
+	}
+	for _, tt := range tests {
+		access, secret, err := loadCredentials(tt.access, tt.secret, tt.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if access != tt.wantAccess || secret != tt.wantSecret {
+			t.Fatalf("got=%s/%s, want=%s/%s", access, secret, tt.wantAccess, tt.wantSecret)
+		}
+	}
+}
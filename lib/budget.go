@@ -0,0 +1,177 @@
+package mpminio
+
+import (
+	"strings"
+	"time"
+)
+
+// budgetSlotDuration is the resolution of the rolling history of requests
+const budgetSlotDuration = 5 * time.Minute
+
+// budgetSlots cover the longest burn rate window
+const budgetSlots = int(72 * time.Hour / budgetSlotDuration)
+
+// burnRateWindows are the rolling windows burn rates are computed over. The last one is
+// also the period of the error budget.
+var burnRateWindows = []struct {
+	name   string
+	window time.Duration
+}{
+	{"1h", time.Hour},
+	{"6h", 6 * time.Hour},
+	{"3d", 72 * time.Hour},
+}
+
+// availabilitySeries are the key prefixes of all requests and failed ones per metrics version
+var availabilitySeries = map[string]struct {
+	requests string
+	errors   string
+}{
+	metricsVersionV1: {"http.request_counts.", "http.request_counts.5"},
+	metricsVersionV2: {"s3.requests.", "s3.errors."},
+	metricsVersionV3: {"api_requests.count.", "api_requests.errors."},
+}
+
+// budgetSlot counts requests observed during budgetSlotDuration from Start
+type budgetSlot struct {
+	Start           int64   `json:"start"`
+	Requests        float64 `json:"requests,omitempty"`
+	Errors          float64 `json:"errors,omitempty"`
+	LatencyRequests float64 `json:"latency_requests,omitempty"`
+	SlowRequests    float64 `json:"slow_requests,omitempty"`
+}
+
+// availabilityCounters are the cumulative request counters of the previous run
+type availabilityCounters struct {
+	Requests float64 `json:"requests"`
+	Errors   float64 `json:"errors"`
+}
+
+// budgetState is the rolling history of requests kept in a ring buffer of slots
+type budgetState struct {
+	Counters *availabilityCounters `json:"counters,omitempty"`
+	Slots    []budgetSlot          `json:"slots,omitempty"`
+}
+
+// slot returns the slot of now, recycling the one left from a previous round of the ring
+func (b *budgetState) slot(now time.Time) *budgetSlot {
+	if len(b.Slots) != budgetSlots {
+		b.Slots = make([]budgetSlot, budgetSlots)
+	}
+	size := int64(budgetSlotDuration / time.Second)
+	start := now.Unix() / size * size
+	s := &b.Slots[(start/size)%int64(budgetSlots)]
+	if s.Start != start {
+		*s = budgetSlot{Start: start}
+	}
+	return s
+}
+
+// sum adds up the slots overlapping the window until now
+func (b budgetState) sum(now time.Time, window time.Duration) budgetSlot {
+	size := int64(budgetSlotDuration / time.Second)
+	from := now.Add(-window).Unix()
+	var total budgetSlot
+	for _, s := range b.Slots {
+		if s.Start+size <= from || s.Start > now.Unix() {
+			continue
+		}
+		total.Requests += s.Requests
+		total.Errors += s.Errors
+		total.LatencyRequests += s.LatencyRequests
+		total.SlowRequests += s.SlowRequests
+	}
+	return total
+}
+
+// recordAvailability adds the requests counted since the previous run.
+// Counters reset by a restart are taken as they are.
+func (b *budgetState) recordAvailability(requests, errors float64, now time.Time) {
+	cur := &availabilityCounters{Requests: requests, Errors: errors}
+	if prev := b.Counters; prev != nil {
+		d := *cur
+		if cur.Requests >= prev.Requests && cur.Errors >= prev.Errors {
+			d.Requests -= prev.Requests
+			d.Errors -= prev.Errors
+		}
+		s := b.slot(now)
+		s.Requests += d.Requests
+		s.Errors += d.Errors
+	}
+	b.Counters = cur
+}
+
+// recordLatency adds the requests observed since the previous run and the ones missing their targets
+func (b *budgetState) recordLatency(requests, slow float64, now time.Time) {
+	if requests <= 0 {
+		return
+	}
+	s := b.slot(now)
+	s.LatencyRequests += requests
+	s.SlowRequests += slow
+}
+
+// availabilityCounts sums up all requests and failed ones of the metrics version.
+// It reports false when no request counter is found.
+func availabilityCounts(stat map[string]interface{}, version string) (float64, float64, bool) {
+	series, ok := availabilitySeries[version]
+	if !ok {
+		series = availabilitySeries[metricsVersionV1]
+	}
+
+	var requests, errors float64
+	found := false
+	for k, v := range stat {
+		f, ok := toFloat(v)
+		if !ok {
+			continue
+		}
+		if strings.HasPrefix(k, series.requests) {
+			requests += f
+			found = true
+		}
+		if strings.HasPrefix(k, series.errors) {
+			errors += f
+		}
+	}
+	return requests, errors, found
+}
+
+// calcBurnRates stores the burn rates of the error budgets over each window, and the percentage
+// of the budgets remaining over the longest one. Objectives are percentages, and zero disables them.
+func calcBurnRates(stat map[string]interface{}, b budgetState, now time.Time, availability, latency float64) {
+	kinds := []struct {
+		name      string
+		objective float64
+		bad       func(budgetSlot) (float64, float64)
+	}{
+		{"availability", availability, func(s budgetSlot) (float64, float64) { return s.Errors, s.Requests }},
+		{"latency", latency, func(s budgetSlot) (float64, float64) { return s.SlowRequests, s.LatencyRequests }},
+	}
+
+	for _, k := range kinds {
+		budget := 1 - k.objective/100
+		if k.objective <= 0 || budget <= 0 {
+			continue
+		}
+		for _, w := range burnRateWindows {
+			bad, total := k.bad(b.sum(now, w.window))
+			if total <= 0 {
+				continue
+			}
+			rate := bad / total / budget
+			stat["slo_burn_rate."+k.name+"."+w.name] = rate
+			if w.window == burnRateWindows[len(burnRateWindows)-1].window {
+				stat["slo_budget_remaining_"+k.name] = (1 - rate) * 100
+			}
+		}
+	}
+}
+
+// latencyObjective returns the latency objective, which requires latency SLOs
+func (m MinioPlugin) latencyObjective() float64 {
+	if len(m.LatencySLOs) == 0 {
+		return 0
+	}
+	return m.LatencyObjective
+}
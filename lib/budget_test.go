@@ -0,0 +1,90 @@
+package mpminio
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestBudgetStateSlot(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	b := budgetState{}
+	b.slot(now).Requests = 1
+	b.slot(now.Add(time.Minute)).Requests++
+	if got := b.sum(now.Add(time.Minute), time.Hour).Requests; got != 2 {
+		t.Fatalf("got=%v, want=2 within a slot", got)
+	}
+
+	// a round of the ring later
+	later := now.Add(72 * time.Hour)
+	if got := b.slot(later).Requests; got != 0 {
+		t.Fatalf("got=%v, want the slot recycled", got)
+	}
+}
+
+func TestCalcBurnRates(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	b := budgetState{}
+	b.recordAvailability(1000, 0, now.Add(-2*time.Hour))
+	b.recordAvailability(2000, 10, now.Add(-90*time.Minute))
+	b.recordAvailability(3000, 40, now)
+	b.recordLatency(100, 5, now)
+
+	stat := map[string]interface{}{}
+	calcBurnRates(stat, b, now, 99, 99)
+	wants := map[string]float64{
+		"slo_burn_rate.availability.1h":     3,
+		"slo_burn_rate.availability.6h":     2,
+		"slo_burn_rate.availability.3d":     2,
+		"slo_budget_remaining_availability": -100,
+		"slo_burn_rate.latency.1h":          5,
+	}
+	for k, want := range wants {
+		if v, ok := stat[k].(float64); !ok || math.Abs(v-want) > 1e-9 {
+			t.Fatalf("%s: got=%v, want=%v", k, stat[k], want)
+		}
+	}
+
+	stat = map[string]interface{}{}
+	calcBurnRates(stat, b, now, 0, 99)
+	if _, ok := stat["slo_burn_rate.availability.1h"]; ok {
+		t.Fatal("availability objective is disabled")
+	}
+}
+
+func TestRecordAvailabilityReset(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	b := budgetState{}
+	b.recordAvailability(3000, 40, now)
+	if got := b.sum(now, time.Hour).Requests; got != 0 {
+		t.Fatalf("got=%v, want nothing on the first run", got)
+	}
+
+	b.recordAvailability(10, 1, now.Add(time.Minute))
+	got := b.sum(now.Add(time.Minute), time.Hour)
+	if got.Requests != 10 || got.Errors != 1 {
+		t.Fatalf("got=%+v, want the counters since the restart", got)
+	}
+}
+
+func TestAvailabilityCounts(t *testing.T) {
+	stat := map[string]interface{}{
+		"s3.requests.getobject": float64(120),
+		"s3.requests.putobject": float64(30),
+		"s3.errors.getobject":   float64(2),
+		"minio_s3_traffic":      float64(1),
+	}
+	requests, errors, ok := availabilityCounts(stat, metricsVersionV2)
+	if !ok || requests != 150 || errors != 2 {
+		t.Fatalf("got=%v/%v/%v", requests, errors, ok)
+	}
+
+	stat = map[string]interface{}{
+		"http.request_counts.200": uint64(90),
+		"http.request_counts.503": uint64(10),
+	}
+	requests, errors, ok = availabilityCounts(stat, metricsVersionV1)
+	if !ok || requests != 100 || errors != 10 {
+		t.Fatalf("got=%v/%v/%v", requests, errors, ok)
+	}
+}
@@ -0,0 +1,172 @@
+package mpminio
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CheckStatus is the result of a check, and its exit code as Mackerel check plugins define
+type CheckStatus int
+
+// Check statuses ordered by severity
+const (
+	CheckOK CheckStatus = iota
+	CheckWarning
+	CheckCritical
+	CheckUnknown
+)
+
+func (s CheckStatus) String() string {
+	switch s {
+	case CheckOK:
+		return "OK"
+	case CheckWarning:
+		return "WARNING"
+	case CheckCritical:
+		return "CRITICAL"
+	}
+	return "UNKNOWN"
+}
+
+// Threshold raises a warning or critical status once a value reaches it. Zero disables it.
+type Threshold struct {
+	Warning  float64
+	Critical float64
+}
+
+// status returns the status of the value against the threshold
+func (t Threshold) status(v float64) CheckStatus {
+	switch {
+	case t.Critical > 0 && v >= t.Critical:
+		return CheckCritical
+	case t.Warning > 0 && v >= t.Warning:
+		return CheckWarning
+	}
+	return CheckOK
+}
+
+// CheckThresholds are the thresholds of each check. ScrapeFailures counts consecutive failed runs,
+// and HealthLatency is in milliseconds.
+type CheckThresholds struct {
+	OfflineDisks   Threshold
+	DiskUsage      Threshold
+	FDUsage        Threshold
+	HealthLatency  Threshold
+	ScrapeFailures Threshold
+}
+
+// checkMetrics lists the metrics checked against each threshold, in the order of the metrics versions
+// preferred. Checks without any of them are skipped.
+var checkMetrics = []struct {
+	name      string
+	keys      []string
+	unit      string
+	threshold func(CheckThresholds) Threshold
+}{
+	{
+		"offline disks",
+		[]string{"minio_offline_disks", "minio_cluster_drive_offline_total", "minio_cluster_health_drives_offline_count", "minio_system_drive_offline_count"},
+		"",
+		func(t CheckThresholds) Threshold { return t.OfflineDisks },
+	},
+	{
+		"missing drives",
+		[]string{"drives_missing"},
+		"",
+		func(t CheckThresholds) Threshold { return t.OfflineDisks },
+	},
+	{
+		"disk usage",
+		[]string{"minio_disk_storage_used_percent", "minio_node_drive_used_percent", "minio_system_drive_used_percent"},
+		"%",
+		func(t CheckThresholds) Threshold { return t.DiskUsage },
+	},
+	{
+		"fd usage",
+		[]string{"process_fds_percentage", "minio_node_file_descriptor_used_percent"},
+		"%",
+		func(t CheckThresholds) Threshold { return t.FDUsage },
+	},
+}
+
+// Check scrapes the server in the same way as FetchMetrics, and returns the most severe status
+// of the checks with a message describing them
+func (m MinioPlugin) Check(t CheckThresholds) (CheckStatus, string) {
+	stat, err := m.FetchMetrics()
+	return evaluateChecks(stat, err, t)
+}
+
+// evaluateChecks evaluates the checks against the metrics scraped
+func evaluateChecks(stat map[string]interface{}, err error, t CheckThresholds) (CheckStatus, string) {
+	status := CheckOK
+	msgs := []string{}
+	raise := func(s CheckStatus) {
+		if s > status {
+			status = s
+		}
+	}
+
+	if stat["scrape_success"] != uint64(1) {
+		failures, _ := toFloat(stat["breaker_consecutive_failures"])
+		s := t.ScrapeFailures.status(failures)
+		raise(s)
+		msgs = append(msgs, fmt.Sprintf("scrape failed %v times in a row (%s)", failures, s))
+	}
+
+	for _, c := range checkMetrics {
+		v, ok := firstMetric(stat, c.keys)
+		if !ok {
+			continue
+		}
+		s := c.threshold(t).status(v)
+		raise(s)
+		msgs = append(msgs, fmt.Sprintf("%s %.4g%s (%s)", c.name, v, c.unit, s))
+	}
+
+	healthy := 0
+	for _, p := range healthProbes {
+		up, ok := stat["health_"+p.name+"_up"]
+		if !ok {
+			continue
+		}
+		if up != uint64(1) {
+			s := CheckWarning
+			if p.critical {
+				s = CheckCritical
+			}
+			raise(s)
+			msgs = append(msgs, fmt.Sprintf("%s probe failed (%s)", p.name, s))
+			continue
+		}
+		if v, ok := toFloat(stat["health_"+p.name+"_latency_ms"]); ok {
+			s := t.HealthLatency.status(v)
+			raise(s)
+			if s != CheckOK {
+				msgs = append(msgs, fmt.Sprintf("%s probe took %.4gms (%s)", p.name, v, s))
+				continue
+			}
+		}
+		healthy++
+	}
+	if healthy > 0 {
+		msgs = append(msgs, fmt.Sprintf("%d health probes passed", healthy))
+	}
+
+	if err != nil {
+		msgs = append(msgs, err.Error())
+	}
+	if len(msgs) == 0 {
+		return CheckUnknown, "no metric to check"
+	}
+	return status, strings.Join(msgs, ", ")
+}
+
+// firstMetric returns the first of the keys found in stat
+func firstMetric(stat map[string]interface{}, keys []string) (float64, bool) {
+	for _, k := range keys {
+		if v, ok := toFloat(stat[k]); ok {
+			return v, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,51 @@
+package mpminio
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+var testCheckThresholds = CheckThresholds{
+	OfflineDisks:   Threshold{1, 2},
+	DiskUsage:      Threshold{80, 90},
+	FDUsage:        Threshold{80, 90},
+	ScrapeFailures: Threshold{1, 3},
+}
+
+func TestCheck(t *testing.T) {
+	s := SetupMockServer(t)
+	defer s.Server.Close()
+
+	status, msg := s.plugin.Check(testCheckThresholds)
+	if status != CheckOK {
+		t.Fatalf("got=%s (%s), want=OK", status, msg)
+	}
+	for _, want := range []string{"offline disks 0", "disk usage 2.644%", "fd usage"} {
+		if !strings.Contains(msg, want) {
+			t.Fatalf("got=%s, want %q", msg, want)
+		}
+	}
+}
+
+func TestEvaluateChecks(t *testing.T) {
+	tests := []struct {
+		stat map[string]interface{}
+		err  error
+		want CheckStatus
+	}{
+		{map[string]interface{}{"scrape_success": uint64(1), "minio_offline_disks": uint64(1)}, nil, CheckWarning},
+		{map[string]interface{}{"scrape_success": uint64(1), "minio_node_drive_used_percent": float64(95)}, nil, CheckCritical},
+		{map[string]interface{}{"scrape_success": uint64(1), "minio_offline_disks": uint64(0), "process_fds_percentage": float64(85)}, nil, CheckWarning},
+		// failures in a row
+		{map[string]interface{}{"scrape_success": uint64(0), "breaker_consecutive_failures": uint64(1)}, errors.New("refused"), CheckWarning},
+		{map[string]interface{}{"scrape_success": uint64(0), "breaker_consecutive_failures": uint64(3)}, errors.New("refused"), CheckCritical},
+		// nothing to check
+		{map[string]interface{}{"scrape_success": uint64(1)}, nil, CheckUnknown},
+	}
+	for _, tt := range tests {
+		if got, msg := evaluateChecks(tt.stat, tt.err, testCheckThresholds); got != tt.want {
+			t.Fatalf("%v: got=%s (%s), want=%s", tt.stat, got, msg, tt.want)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package mpminio
+
+import (
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+)
+
+const (
+	aggregateMin = "min"
+	// aggregateCounterSum sums counters only when every node reports them, since the sum of the
+	// nodes responding drops and jumps back as a node misses a run, which graphs as a spike
+	aggregateCounterSum = "counter_sum"
+)
+
+// clusterMetrics are aggregated across the nodes. Each node contributes the first of the keys it has,
+// since the metrics versions name the same metric differently. A key with * is the sum of the series
+// matching it, e.g. the requests of every type.
+var clusterMetrics = []struct {
+	key       string
+	keys      []string
+	aggregate string
+}{
+	{"cluster_network_received_bytes", []string{"minio_network_received_bytes_total", "minio_s3_traffic_received_bytes", "minio_api_requests_traffic_received_bytes"}, aggregateCounterSum},
+	{"cluster_network_sent_bytes", []string{"minio_network_sent_bytes_total", "minio_s3_traffic_sent_bytes", "minio_api_requests_traffic_sent_bytes"}, aggregateCounterSum},
+	// v1 counts the requests of each type in the histogram of their durations, but not their errors
+	{"cluster_requests", []string{"http_duration.*.total", "s3.requests.*", "api_requests.count.*"}, aggregateCounterSum},
+	{"cluster_request_errors", []string{"s3.errors.*", "api_requests.errors.*"}, aggregateCounterSum},
+	{"cluster_disk_usage_max_percent", []string{"minio_disk_storage_used_percent", "minio_node_drive_used_percent", "minio_system_drive_used_percent"}, aggregateMax},
+	{"cluster_free_bytes_min", []string{"minio_disk_storage_available_bytes", "minio_node_drive_free_bytes", "minio_system_drive_free_bytes"}, aggregateMin},
+	// Drives offline on each node add up
+	{"cluster_offline_disks", []string{"minio_offline_disks", "minio_system_drive_offline_count"}, aggregateSum},
+}
+
+// clusterWideOfflineDisks are reported by every node for the whole cluster, so they are not added up
+var clusterWideOfflineDisks = []string{"minio_cluster_drive_offline_total", "minio_cluster_health_drives_offline_count"}
+
+// calcClusterMetrics aggregates the metrics of the nodes into cluster totals and statistics.
+// Nodes failing to respond are counted, but do not contribute to the others.
+func calcClusterMetrics(stats []map[string]interface{}) map[string]interface{} {
+	cluster := map[string]interface{}{
+		"cluster_nodes_total": uint64(len(stats)),
+	}
+	up := uint64(0)
+	for _, stat := range stats {
+		if stat["scrape_success"] == uint64(1) {
+			up++
+		}
+	}
+	cluster["cluster_nodes_up"] = up
+
+	for _, c := range clusterMetrics {
+		var result float64
+		found := 0
+		for _, stat := range stats {
+			v, ok := clusterInput(stat, c.keys)
+			if !ok {
+				continue
+			}
+			switch {
+			case found == 0:
+				result = v
+			case c.aggregate == aggregateMax && v > result, c.aggregate == aggregateMin && v < result:
+				result = v
+			case c.aggregate == aggregateSum, c.aggregate == aggregateCounterSum:
+				result += v
+			}
+			found++
+		}
+		if found > 0 && (c.aggregate != aggregateCounterSum || found == len(stats)) {
+			cluster[c.key] = result
+		}
+	}
+
+	// Without node local counts, fall back to the largest of the cluster wide ones
+	if _, ok := cluster["cluster_offline_disks"]; !ok {
+		found := false
+		var offline float64
+		for _, stat := range stats {
+			if v, ok := firstMetric(stat, clusterWideOfflineDisks); ok && (!found || v > offline) {
+				offline = v
+				found = true
+			}
+		}
+		if found {
+			cluster["cluster_offline_disks"] = offline
+		}
+	}
+	return cluster
+}
+
+// clusterInput returns the value of the first key the node has, or the sum of the series
+// matching the first key with * the node has any of
+func clusterInput(stat map[string]interface{}, keys []string) (float64, bool) {
+	for _, key := range keys {
+		i := strings.Index(key, "*")
+		if i < 0 {
+			if v, ok := toFloat(stat[key]); ok {
+				return v, true
+			}
+			continue
+		}
+		prefix, suffix := key[:i], key[i+1:]
+		var sum float64
+		found := false
+		for k, v := range stat {
+			if !strings.HasPrefix(k, prefix) || !strings.HasSuffix(k, suffix) || len(k) <= len(prefix)+len(suffix) {
+				continue
+			}
+			if f, ok := toFloat(v); ok {
+				sum += f
+				found = true
+			}
+		}
+		if found {
+			return sum, true
+		}
+	}
+	return 0, false
+}
+
+func graphDefinitionCluster(labelPrefix string) map[string]mp.Graphs {
+	return map[string]mp.Graphs{
+		"cluster.nodes": {
+			Label: (labelPrefix + " Cluster Nodes Responding"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "cluster_nodes_up", Label: "Up", Type: "uint64"},
+				{Name: "cluster_nodes_total", Label: "Total", Type: "uint64"},
+			},
+		},
+		"cluster.network": {
+			Label: (labelPrefix + " Cluster Network Traffic"),
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "cluster_network_received_bytes", Label: "Received", Diff: true},
+				{Name: "cluster_network_sent_bytes", Label: "Sent", Diff: true},
+			},
+		},
+		"cluster.requests": {
+			Label: (labelPrefix + " Cluster Requests"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "cluster_requests", Label: "Requests", Diff: true},
+				{Name: "cluster_request_errors", Label: "Errors", Diff: true},
+			},
+		},
+		"cluster.disk_usage": {
+			Label: (labelPrefix + " Cluster Max Disk Usage Percentage"),
+			Unit:  "percentage",
+			Metrics: []mp.Metrics{
+				{Name: "cluster_disk_usage_max_percent", Label: "Max Used"},
+			},
+		},
+		"cluster.free_capacity": {
+			Label: (labelPrefix + " Cluster Min Free Capacity"),
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "cluster_free_bytes_min", Label: "Min Free"},
+			},
+		},
+		"cluster.disks": {
+			Label: (labelPrefix + " Cluster Offline Disks"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "cluster_offline_disks", Label: "Offline"},
+			},
+		},
+	}
+}
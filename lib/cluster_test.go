@@ -0,0 +1,79 @@
+package mpminio
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCalcClusterMetrics(t *testing.T) {
+	stats := []map[string]interface{}{
+		{
+			"scrape_success":                     uint64(1),
+			"minio_network_received_bytes_total": float64(100),
+			"http_duration.GET.total":            uint64(90),
+			"http_duration.GET.0_001":            uint64(80),
+			"http_duration.PUT.total":            uint64(10),
+			// scrapes of the metrics endpoint are not requests
+			"http.request_counts.200":            uint64(5),
+			"minio_disk_storage_used_percent":    float64(40),
+			"minio_disk_storage_available_bytes": float64(600),
+			"minio_offline_disks":                uint64(1),
+		},
+		{
+			"scrape_success":                  uint64(1),
+			"minio_s3_traffic_received_bytes": float64(50),
+			"s3.requests.getobject":           float64(30),
+			"s3.errors.getobject":             float64(2),
+			"minio_node_drive_used_percent":   float64(70),
+			"minio_node_drive_free_bytes":     float64(300),
+			"minio_offline_disks":             uint64(2),
+		},
+	}
+
+	got := calcClusterMetrics(stats)
+	want := map[string]interface{}{
+		"cluster_nodes_total":            uint64(2),
+		"cluster_nodes_up":               uint64(2),
+		"cluster_network_received_bytes": float64(150),
+		"cluster_requests":               float64(130),
+		"cluster_disk_usage_max_percent": float64(70),
+		"cluster_free_bytes_min":         float64(300),
+		"cluster_offline_disks":          float64(3),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got=%v, want=%v", got, want)
+	}
+
+	// counters are left out while a node is down, not to graph the drop and the jump back as a spike
+	stats = append(stats, map[string]interface{}{"scrape_success": uint64(0)})
+	got = calcClusterMetrics(stats)
+	for _, k := range []string{"cluster_network_received_bytes", "cluster_requests"} {
+		if v, ok := got[k]; ok {
+			t.Fatalf("%s: got=%v, want nothing", k, v)
+		}
+	}
+	if got["cluster_nodes_up"] != uint64(2) || got["cluster_disk_usage_max_percent"] != float64(70) {
+		t.Fatalf("got=%v, want the gauges of the nodes up", got)
+	}
+}
+
+func TestCalcClusterMetricsClusterWideOfflineDisks(t *testing.T) {
+	stats := []map[string]interface{}{
+		{"minio_cluster_drive_offline_total": float64(1)},
+		{"minio_cluster_drive_offline_total": float64(2)},
+	}
+	if got := calcClusterMetrics(stats)["cluster_offline_disks"]; got != float64(2) {
+		t.Fatalf("got=%v, want=2 rather than the sum", got)
+	}
+}
+
+func TestCalcClusterMetricsRequestErrors(t *testing.T) {
+	stats := []map[string]interface{}{
+		{"api_requests.count.GetObject": float64(30), "api_requests.errors.GetObject": float64(2)},
+		{"api_requests.count.PutObject": float64(20), "api_requests.errors.PutObject": float64(1)},
+	}
+	got := calcClusterMetrics(stats)
+	if got["cluster_requests"] != float64(50) || got["cluster_request_errors"] != float64(3) {
+		t.Fatalf("got=%v, want 50 requests and 3 errors", got)
+	}
+}
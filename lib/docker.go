@@ -0,0 +1,163 @@
+package mpminio
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultDockerSocket is the unix socket Docker Engine API listens on
+const DefaultDockerSocket = "/var/run/docker.sock"
+
+// defaultDockerImage selects containers when no selector is given
+const defaultDockerImage = "minio/minio"
+
+// minioContainerPort is the port MinIO serves the API on in its image
+const minioContainerPort = 9000
+
+// DockerSelector selects the containers to scrape by a label, either "key" or "key=value", and by an image
+// name without a registry or tag, e.g. "minio/minio". Both must match when both are given.
+type DockerSelector struct {
+	Label string
+	Image string
+}
+
+// dockerContainer is a container listed by Docker Engine API
+type dockerContainer struct {
+	Names  []string          `json:"Names"`
+	Image  string            `json:"Image"`
+	Labels map[string]string `json:"Labels"`
+	Ports  []struct {
+		IP          string `json:"IP"`
+		PrivatePort int    `json:"PrivatePort"`
+		PublicPort  int    `json:"PublicPort"`
+		Type        string `json:"Type"`
+	} `json:"Ports"`
+}
+
+// DiscoverContainers lists the running containers through Docker Engine API on the unix socket, and returns
+// a node for each selected one with the other settings of base. Nodes are named by their containers,
+// and scraped on the port published for 9000. Containers without it are skipped.
+func DiscoverContainers(ctx context.Context, socket string, base MinioPlugin, selector DockerSelector) ([]MinioPlugin, error) {
+	if selector.Label == "" && selector.Image == "" {
+		selector.Image = defaultDockerImage
+	}
+	containers, err := listContainers(ctx, socket)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []MinioPlugin{}
+	for _, c := range containers {
+		if !selector.matches(c) || len(c.Names) == 0 {
+			continue
+		}
+		host, port, ok := c.published(minioContainerPort)
+		if !ok {
+			continue
+		}
+		node := base
+		node.Name = strings.TrimPrefix(c.Names[0], "/")
+		node.Host = host
+		node.Port = port
+		nodes = append(nodes, node)
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no MinIO container publishing port %d is found", minioContainerPort)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodeID(nodes[i]) < nodeID(nodes[j]) })
+	return nodes, nil
+}
+
+// listContainers requests the running containers to Docker Engine API
+func listContainers(ctx context.Context, socket string) ([]dockerContainer, error) {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	// The host is not used but required in the URL
+	req, err := http.NewRequest(http.MethodGet, "http://docker/containers/json", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("listing containers failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("listing containers failed: %s", resp.Status)
+	}
+
+	var containers []dockerContainer
+	if err := json.NewDecoder(resp.Body).Decode(&containers); err != nil {
+		return nil, fmt.Errorf("parsing containers failed: %v", err)
+	}
+	return containers, nil
+}
+
+// matches reports whether the container is selected
+func (s DockerSelector) matches(c dockerContainer) bool {
+	if s.Label != "" {
+		key, value := s.Label, ""
+		i := strings.Index(s.Label, "=")
+		if i >= 0 {
+			key, value = s.Label[:i], s.Label[i+1:]
+		}
+		v, ok := c.Labels[key]
+		if !ok || i >= 0 && v != value {
+			return false
+		}
+	}
+	if s.Image != "" && imageName(c.Image) != s.Image {
+		return false
+	}
+	return true
+}
+
+// imageName strips the registry, tag and digest of an image, e.g. quay.io/minio/minio:latest into minio/minio
+func imageName(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	// a registry has a dot or a port, or is localhost
+	if i := strings.Index(image, "/"); i >= 0 {
+		if r := image[:i]; strings.ContainsAny(r, ".:") || r == "localhost" {
+			image = image[i+1:]
+		}
+	}
+	return image
+}
+
+// published returns the host and port the container port is published on. IPv4 is preferred
+// since Docker lists the port for both, and every interface means the local host.
+func (c dockerContainer) published(port int) (string, string, bool) {
+	host, published := "", 0
+	for _, p := range c.Ports {
+		if p.PrivatePort != port || p.Type != "tcp" || p.PublicPort == 0 {
+			continue
+		}
+		if published == 0 || strings.Contains(host, ":") && !strings.Contains(p.IP, ":") {
+			host, published = p.IP, p.PublicPort
+		}
+	}
+	if published == 0 {
+		return "", "", false
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "localhost"
+	}
+	return host, strconv.Itoa(published), true
+}
@@ -0,0 +1,116 @@
+package mpminio
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// dockerContainers are listed by the fake Docker Engine API
+const dockerContainers = `[
+	{"Names": ["/minio1"], "Image": "quay.io/minio/minio:RELEASE.2024-01-01T00-00-00Z", "Labels": {},
+	 "Ports": [{"IP": "::", "PrivatePort": 9000, "PublicPort": 49154, "Type": "tcp"},
+	           {"IP": "0.0.0.0", "PrivatePort": 9000, "PublicPort": 49153, "Type": "tcp"},
+	           {"IP": "0.0.0.0", "PrivatePort": 9001, "PublicPort": 49155, "Type": "tcp"}]},
+	{"Names": ["/minio2"], "Image": "minio/minio", "Labels": {"role": "minio"},
+	 "Ports": [{"IP": "127.0.0.1", "PrivatePort": 9000, "PublicPort": 19000, "Type": "tcp"}]},
+	{"Names": ["/minio3"], "Image": "minio/minio:latest", "Labels": {"role": "minio"},
+	 "Ports": [{"PrivatePort": 9000, "Type": "tcp"}]},
+	{"Names": ["/custom"], "Image": "registry.example.net:5000/ops/minio@sha256:0123", "Labels": {"role": "minio"},
+	 "Ports": [{"IP": "0.0.0.0", "PrivatePort": 9000, "PublicPort": 29000, "Type": "tcp"}]},
+	{"Names": ["/redis"], "Image": "redis:7", "Labels": {"role": "cache"},
+	 "Ports": [{"IP": "0.0.0.0", "PrivatePort": 6379, "PublicPort": 6379, "Type": "tcp"}]}
+]`
+
+// dockerServer serves the fake Docker Engine API on a unix socket
+func dockerServer(t *testing.T, dir string) *httptest.Server {
+	socket := filepath.Join(dir, "docker.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/containers/json" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(dockerContainers))
+	}))
+	s.Listener.Close()
+	s.Listener = l
+	s.Start()
+	return s
+}
+
+func TestDiscoverContainers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mackerel-plugin-minio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := dockerServer(t, dir)
+	defer s.Close()
+	socket := filepath.Join(dir, "docker.sock")
+
+	base := MinioPlugin{Scheme: "http", Host: "ignored", Port: "1", Prefix: "minio"}
+	tests := []struct {
+		selector DockerSelector
+		want     map[string]string
+	}{
+		{
+			DockerSelector{},
+			map[string]string{"minio1": "http://localhost:49153", "minio2": "http://127.0.0.1:19000"},
+		},
+		{
+			DockerSelector{Label: "role=minio"},
+			map[string]string{"minio2": "http://127.0.0.1:19000", "custom": "http://localhost:29000"},
+		},
+		{
+			DockerSelector{Label: "role", Image: "ops/minio"},
+			map[string]string{"custom": "http://localhost:29000"},
+		},
+	}
+	for _, tt := range tests {
+		nodes, err := DiscoverContainers(context.Background(), socket, base, tt.selector)
+		if err != nil {
+			t.Fatalf("%+v: %v", tt.selector, err)
+		}
+		got := map[string]string{}
+		for _, n := range nodes {
+			u := n.endpoint("")
+			got[nodeID(n)] = u.String()
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%+v: got=%v, want=%v", tt.selector, got, tt.want)
+		}
+	}
+
+	if _, err := DiscoverContainers(context.Background(), socket, base, DockerSelector{Label: "role=cache"}); err == nil {
+		t.Fatal("want an error without containers publishing 9000")
+	}
+	if _, err := DiscoverContainers(context.Background(), filepath.Join(dir, "missing.sock"), base, DockerSelector{}); err == nil {
+		t.Fatal("want an error without Docker")
+	}
+}
+
+func TestImageName(t *testing.T) {
+	tests := map[string]string{
+		"minio/minio":                           "minio/minio",
+		"minio/minio:latest":                    "minio/minio",
+		"quay.io/minio/minio:RELEASE.2024":      "minio/minio",
+		"localhost:5000/minio@sha256:0123":      "minio",
+		"registry.example.net:5000/ops/minio:1": "ops/minio",
+	}
+	for image, want := range tests {
+		if got := imageName(image); got != want {
+			t.Fatalf("%s: got=%s, want=%s", image, got, want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package mpminio
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ellipsisPattern matches a range such as {1...8}, {01...16} or {0a...1f}
+var ellipsisPattern = regexp.MustCompile(`\{([0-9a-z]+)\.\.\.([0-9a-z]+)\}`)
+
+// expandEllipses expands every range in s, e.g. "minio{1...2}/data{1...2}" into
+// minio1/data1, minio1/data2, minio2/data1 and minio2/data2
+func expandEllipses(s string) ([]string, error) {
+	loc := ellipsisPattern.FindStringSubmatchIndex(s)
+	if loc == nil {
+		if strings.Contains(s, "...") {
+			return nil, fmt.Errorf("invalid ellipsis in %q", s)
+		}
+		return []string{s}, nil
+	}
+
+	values, err := ellipsisValues(s[loc[2]:loc[3]], s[loc[4]:loc[5]])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ellipsis in %q: %v", s, err)
+	}
+	rest, err := expandEllipses(s[loc[1]:])
+	if err != nil {
+		return nil, err
+	}
+
+	expanded := []string{}
+	for _, v := range values {
+		for _, r := range rest {
+			expanded = append(expanded, s[:loc[0]]+v+r)
+		}
+	}
+	return expanded, nil
+}
+
+// ellipsisValues returns the values from start to end as MinIO expands them. Each end is decimal, or else
+// hexadecimal, which makes the values hexadecimal. Ends starting with 0 are zero padded.
+func ellipsisValues(start, end string) ([]string, error) {
+	from, hex1, err := parseEllipsisEnd(start)
+	if err != nil {
+		return nil, err
+	}
+	to, hex2, err := parseEllipsisEnd(end)
+	if err != nil {
+		return nil, err
+	}
+	if from > to {
+		return nil, fmt.Errorf("descending range %s...%s", start, end)
+	}
+
+	verb := "d"
+	if hex1 || hex2 {
+		verb = "x"
+	}
+	format := "%" + verb
+	if len(start) > 1 && start[0] == '0' {
+		format = "%0" + strconv.Itoa(len(start)) + verb
+	}
+	values := []string{}
+	for i := from; i <= to; i++ {
+		values = append(values, fmt.Sprintf(format, i))
+	}
+	return values, nil
+}
+
+// parseEllipsisEnd parses an end of a range, reporting whether it is hexadecimal
+func parseEllipsisEnd(s string) (uint64, bool, error) {
+	if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return n, false, nil
+	}
+	n, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s is neither decimal nor hexadecimal", s)
+	}
+	return n, true, nil
+}
+
+// ParseServers parses the arguments of minio server, or MINIO_VOLUMES, such as
+// "http://minio{1...4}:9000/data{1...4} http://minio{5...8}:9000/data{1...4}" into the unique nodes
+// with the number of drives expected on each. Drives without a URL are the ones of base.
+// Server pools are separated by spaces.
+func ParseServers(s string, base MinioPlugin) ([]MinioPlugin, error) {
+	nodes := map[string]MinioPlugin{}
+	for _, arg := range strings.Fields(s) {
+		drives, err := expandEllipses(arg)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range drives {
+			node := base
+			if strings.Contains(d, "://") {
+				u, err := url.Parse(d)
+				if err != nil || u.Hostname() == "" {
+					return nil, fmt.Errorf("invalid server %q", d)
+				}
+				node.Scheme = u.Scheme
+				node.Host = u.Hostname()
+				if p := u.Port(); p != "" {
+					node.Port = p
+				}
+			}
+
+			id := nodeID(node)
+			if n, ok := nodes[id]; ok {
+				node = n
+			}
+			node.ExpectedDrives++
+			nodes[id] = node
+		}
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no server is given in %q", s)
+	}
+
+	sorted := make([]MinioPlugin, 0, len(nodes))
+	for _, n := range nodes {
+		sorted = append(sorted, n)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return nodeID(sorted[i]) < nodeID(sorted[j]) })
+	return sorted, nil
+}
+
+// driveCounts are the metrics counting the drives of a node. Every key of the first set found is summed.
+var driveCounts = [][]string{
+	{"minio_total_disks"},
+	{"minio_system_drive_online_count", "minio_system_drive_offline_count"},
+}
+
+// calcExpectedDrives stores the number of drives expected on the node, and the ones missing from the metrics.
+// Without a count, as on v2, the drives reporting their capacity are counted.
+func calcExpectedDrives(stat map[string]interface{}, expected int) {
+	if expected <= 0 {
+		return
+	}
+	stat["drives_expected"] = uint64(expected)
+
+	for _, keys := range driveCounts {
+		var total float64
+		found := false
+		for _, k := range keys {
+			if v, ok := toFloat(stat[k]); ok {
+				total += v
+				found = true
+			}
+		}
+		if found {
+			stat["drives_missing"] = float64(expected) - total
+			return
+		}
+	}
+
+	drives := 0
+	for k := range stat {
+		if strings.HasPrefix(k, "node.drives.") && strings.HasSuffix(k, ".total_bytes") {
+			drives++
+		}
+	}
+	if drives > 0 {
+		stat["drives_missing"] = float64(expected - drives)
+	}
+}
@@ -0,0 +1,102 @@
+package mpminio
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandEllipses(t *testing.T) {
+	tests := map[string][]string{
+		"/data":                     {"/data"},
+		"minio{1...2}/data{1...2}":  {"minio1/data1", "minio1/data2", "minio2/data1", "minio2/data2"},
+		"/data{08...10}":            {"/data08", "/data09", "/data10"},
+		"http://minio-{a...c}:9000": {"http://minio-a:9000", "http://minio-b:9000", "http://minio-c:9000"},
+		// hexadecimal as MinIO parses ends failing as decimal
+		"/data{09...0b}": {"/data09", "/data0a", "/data0b"},
+		"/data{9...b}":   {"/data9", "/dataa", "/datab"},
+	}
+	for s, want := range tests {
+		got, err := expandEllipses(s)
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: got=%v, want=%v", s, got, want)
+		}
+	}
+
+	got, err := expandEllipses("/data{0a...1f}")
+	if err != nil || len(got) != 22 || got[0] != "/data0a" || got[21] != "/data1f" {
+		t.Fatalf("got=%v (%v), want /data0a to /data1f", got, err)
+	}
+
+	// 11 is decimal, before 0e
+	for _, s := range []string{"/data{4...1}", "/data{0e...11}", "/data{a...z}", "/data{1....4}", "/data{1...}"} {
+		if _, err := expandEllipses(s); err == nil {
+			t.Fatalf("%s: want an error", s)
+		}
+	}
+}
+
+func TestParseServers(t *testing.T) {
+	base := MinioPlugin{Scheme: "http", Host: "localhost", Port: "9000", Prefix: "minio"}
+
+	// two pools, with minio2 in both
+	nodes, err := ParseServers("http://minio{1...2}:9000/data{1...4}  https://minio{2...3}:9443/disk{1...2}", base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]int{}
+	for _, n := range nodes {
+		u := n.endpoint("")
+		got[u.String()] = n.ExpectedDrives
+	}
+	want := map[string]int{
+		"http://minio1:9000":  4,
+		"http://minio2:9000":  4,
+		"https://minio2:9443": 2,
+		"https://minio3:9443": 2,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got=%v, want=%v", got, want)
+	}
+
+	// drives without URLs are local to a single node
+	nodes, err = ParseServers("/mnt/data{1...4}", base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 || nodes[0].Host != "localhost" || nodes[0].ExpectedDrives != 4 {
+		t.Fatalf("got=%+v, want localhost with 4 drives", nodes)
+	}
+
+	for _, s := range []string{"", "http://:9000/data", "http://minio{2...1}/data"} {
+		if _, err := ParseServers(s, base); err == nil {
+			t.Fatalf("%q: want an error", s)
+		}
+	}
+}
+
+func TestCalcExpectedDrives(t *testing.T) {
+	stat := map[string]interface{}{"minio_system_drive_online_count": 3.0, "minio_system_drive_offline_count": 0.0}
+	calcExpectedDrives(stat, 4)
+	if stat["drives_expected"] != uint64(4) || stat["drives_missing"] != 1.0 {
+		t.Fatalf("got=%v", stat)
+	}
+
+	// v2 reports each drive
+	stat = map[string]interface{}{
+		"node.drives._data1.total_bytes": 100.0, "node.drives._data1.used_bytes": 50.0,
+		"node.drives._data2.total_bytes": 100.0, "minio_node_drive_total_bytes": 200.0,
+	}
+	calcExpectedDrives(stat, 4)
+	if stat["drives_missing"] != 2.0 {
+		t.Fatalf("got=%v, want 2 drives missing", stat)
+	}
+
+	stat = map[string]interface{}{"minio_total_disks": 4.0}
+	calcExpectedDrives(stat, 0)
+	if _, ok := stat["drives_expected"]; ok {
+		t.Fatalf("got=%v, want nothing without expected drives", stat)
+	}
+}
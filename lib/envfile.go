@@ -0,0 +1,143 @@
+package mpminio
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultMinioEnvFile is where the minio service reads its environment on most packages
+const DefaultMinioEnvFile = "/etc/default/minio"
+
+// MinioEnv is the configuration of a MinIO server read from its environment file
+type MinioEnv struct {
+	// Volumes are the server arguments given by MINIO_VOLUMES
+	Volumes string
+	// Host and Port are given by --address of MINIO_OPTS, or MINIO_ADDRESS. Host is empty for every interface.
+	Host string
+	Port string
+	// CertsDir is given by --certs-dir or -S of MINIO_OPTS
+	CertsDir  string
+	AccessKey string
+	SecretKey string
+}
+
+// LoadMinioEnv reads an environment file of the minio service, such as /etc/default/minio.
+// Lines are KEY=value, optionally quoted and exported, as systemd and shells accept.
+func LoadMinioEnv(file string) (MinioEnv, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return MinioEnv{}, fmt.Errorf("reading MinIO environment file failed: %v", err)
+	}
+	defer f.Close()
+	return parseMinioEnv(f, file)
+}
+
+// parseMinioEnv parses the environment read from r, named file in errors
+func parseMinioEnv(r io.Reader, file string) (MinioEnv, error) {
+	vars := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		i := strings.Index(line, "=")
+		if i <= 0 {
+			return MinioEnv{}, fmt.Errorf("invalid line in %s: %q", file, line)
+		}
+		vars[strings.TrimSpace(line[:i])] = unquote(strings.TrimSpace(line[i+1:]))
+	}
+	if err := scanner.Err(); err != nil {
+		return MinioEnv{}, fmt.Errorf("reading MinIO environment file failed: %v", err)
+	}
+
+	env := MinioEnv{
+		Volumes:   vars["MINIO_VOLUMES"],
+		AccessKey: firstNonEmpty(vars["MINIO_ROOT_USER"], vars["MINIO_ACCESS_KEY"]),
+		SecretKey: firstNonEmpty(vars["MINIO_ROOT_PASSWORD"], vars["MINIO_SECRET_KEY"]),
+	}
+	address := vars["MINIO_ADDRESS"]
+	opts := strings.Fields(vars["MINIO_OPTS"])
+	for i := 0; i < len(opts); i++ {
+		name, value, inline := opts[i], "", false
+		if j := strings.Index(name, "="); j > 0 {
+			name, value, inline = name[:j], name[j+1:], true
+		}
+		if name != "--address" && name != "--certs-dir" && name != "-S" {
+			continue
+		}
+		if !inline && i+1 < len(opts) {
+			i++
+			value = opts[i]
+		}
+		if name == "--address" {
+			address = unquote(value)
+		} else {
+			env.CertsDir = unquote(value)
+		}
+	}
+
+	if address != "" {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return MinioEnv{}, fmt.Errorf("invalid address %q in %s: %v", address, file, err)
+		}
+		if host != "0.0.0.0" && host != "::" {
+			env.Host = host
+		}
+		env.Port = port
+	}
+	return env, nil
+}
+
+// unquote strips the quotes around a value
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// firstNonEmpty returns the first of the values which is not empty
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Apply fills in the settings of m the environment provides, except the ones in set, which are the names
+// of flags given explicitly. The server certificate in the certs dir turns on TLS and is trusted.
+func (env MinioEnv) Apply(m *MinioPlugin, set map[string]bool) {
+	if env.Host != "" && !set["host"] {
+		m.Host = env.Host
+	}
+	if env.Port != "" && !set["port"] {
+		m.Port = env.Port
+	}
+	if env.CertsDir != "" {
+		cert := filepath.Join(env.CertsDir, "public.crt")
+		if _, err := os.Stat(cert); err == nil {
+			if !set["scheme"] {
+				m.Scheme = "https"
+			}
+			if !set["ca-cert"] && !set["insecure"] {
+				m.CACert = cert
+			}
+		}
+	}
+	if env.AccessKey != "" && !set["access-key"] && !set["credentials-file"] {
+		m.AccessKey = env.AccessKey
+	}
+	if env.SecretKey != "" && !set["secret-key"] && !set["credentials-file"] {
+		m.SecretKey = env.SecretKey
+	}
+}
@@ -0,0 +1,73 @@
+package mpminio
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadMinioEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mackerel-plugin-minio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certsDir := filepath.Join(dir, "certs")
+	if err := os.Mkdir(certsDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(certsDir, "public.crt"), []byte("cert"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "minio")
+	content := `# Volume to be used for MinIO server.
+MINIO_VOLUMES="http://minio{1...4}:9100/mnt/data{1...2}"
+MINIO_OPTS="--address :9100 --console-address :9001 --certs-dir=` + certsDir + `"
+export MINIO_ROOT_USER='minioadmin'
+MINIO_ROOT_PASSWORD=minioadmin-secret
+`
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	env, err := LoadMinioEnv(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := MinioEnv{
+		Volumes:   "http://minio{1...4}:9100/mnt/data{1...2}",
+		Port:      "9100",
+		CertsDir:  certsDir,
+		AccessKey: "minioadmin",
+		SecretKey: "minioadmin-secret",
+	}
+	if !reflect.DeepEqual(env, want) {
+		t.Fatalf("got=%+v, want=%+v", env, want)
+	}
+
+	m := MinioPlugin{Scheme: "http", Host: "localhost", Port: "9000", AccessKey: "flag"}
+	env.Apply(&m, map[string]bool{"access-key": true})
+	if m.Scheme != "https" || m.Host != "localhost" || m.Port != "9100" || m.CACert != filepath.Join(certsDir, "public.crt") {
+		t.Fatalf("got=%+v, want https on port 9100 trusting the certificate", m)
+	}
+	if m.AccessKey != "flag" || m.SecretKey != "minioadmin-secret" {
+		t.Fatalf("got=%s/%s, want the access key of the flag", m.AccessKey, m.SecretKey)
+	}
+
+	// explicit flags win
+	m = MinioPlugin{Scheme: "http", Port: "9000"}
+	env.Apply(&m, map[string]bool{"scheme": true, "port": true})
+	if m.Scheme != "http" || m.Port != "9000" {
+		t.Fatalf("got=%+v, want the flags kept", m)
+	}
+
+	if err := ioutil.WriteFile(file, []byte("MINIO_OPTS=\"--address minio1\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadMinioEnv(file); err == nil {
+		t.Fatal("want an error of the address without a port")
+	}
+}
@@ -0,0 +1,116 @@
+package mpminio
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+)
+
+// writeQuorumHeader tells the number of drives needed for writes, returned by the cluster probes
+const writeQuorumHeader = "X-Minio-Write-Quorum"
+
+// healthProbes are the health endpoints of MinIO.
+// FYI, see https://min.io/docs/minio/linux/operations/monitoring/healthcheck-probe.html
+var healthProbes = []struct {
+	name  string
+	label string
+	path  string
+	query string
+	// critical tells whether failing the probe is critical rather than a warning in check mode
+	critical bool
+}{
+	{"live", "Live", "/minio/health/live", "", true},
+	{"ready", "Ready", "/minio/health/ready", "", true},
+	{"cluster", "Cluster Write", "/minio/health/cluster", "", true},
+	{"cluster_read", "Cluster Read", "/minio/health/cluster/read", "", true},
+	// Fails with 412 when taking the node down would lose write quorum
+	{"maintenance", "Maintenance", "/minio/health/cluster", "maintenance=true", false},
+}
+
+// defaultHealthProbes are probed unless given explicitly
+var defaultHealthProbes = []string{"live", "ready", "cluster", "cluster_read", "maintenance"}
+
+// probeHealth calls the configured health endpoints, storing whether each is up with its latency
+// in milliseconds, and the write quorum reported by the cluster probes
+func (m MinioPlugin) probeHealth(ctx context.Context, stat map[string]interface{}) {
+	for _, p := range healthProbes {
+		if !m.probes(p.name) {
+			continue
+		}
+
+		u := m.endpoint(p.path)
+		u.RawQuery = p.query
+		start := time.Now()
+		resp, err := m.do(ctx, u)
+		if err != nil {
+			// Out of time after a slow scrape, the probe tells nothing about the health
+			if ctx.Err() == nil {
+				stat["health_"+p.name+"_up"] = uint64(0)
+			}
+			continue
+		}
+		stat["health_"+p.name+"_latency_ms"] = float64(time.Since(start)) / float64(time.Millisecond)
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusOK {
+			stat["health_"+p.name+"_up"] = uint64(1)
+		} else {
+			stat["health_"+p.name+"_up"] = uint64(0)
+		}
+		if q, err := strconv.ParseUint(resp.Header.Get(writeQuorumHeader), 10, 64); err == nil {
+			stat["health_write_quorum"] = q
+		}
+	}
+}
+
+// probes reports whether the health probe is configured
+func (m MinioPlugin) probes(name string) bool {
+	for _, p := range m.HealthProbes {
+		if strings.TrimSpace(p) == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (m MinioPlugin) graphDefinitionHealth(labelPrefix string) map[string]mp.Graphs {
+	up := []mp.Metrics{}
+	latency := []mp.Metrics{}
+	for _, p := range healthProbes {
+		if !m.probes(p.name) {
+			continue
+		}
+		up = append(up, mp.Metrics{Name: "health_" + p.name + "_up", Label: p.label, Type: "uint64"})
+		latency = append(latency, mp.Metrics{Name: "health_" + p.name + "_latency_ms", Label: p.label})
+	}
+	if len(up) == 0 {
+		return nil
+	}
+
+	return map[string]mp.Graphs{
+		"health.up": {
+			Label:   (labelPrefix + " Health"),
+			Unit:    "integer",
+			Metrics: up,
+		},
+		"health.latency": {
+			Label:   (labelPrefix + " Health Probe Latency (ms)"),
+			Unit:    "float",
+			Metrics: latency,
+		},
+		"health.quorum": {
+			Label: (labelPrefix + " Write Quorum"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "health_write_quorum", Label: "Drives", Type: "uint64"},
+			},
+		},
+	}
+}
@@ -0,0 +1,95 @@
+package mpminio
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// healthServer serves a node which is alive, but whose cluster lost write quorum
+func healthServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/minio/health/live", "/minio/health/ready", "/minio/health/cluster/read":
+			w.WriteHeader(http.StatusOK)
+		case "/minio/health/cluster":
+			w.Header().Set(writeQuorumHeader, "3")
+			if r.URL.Query().Get("maintenance") == "true" {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestProbeHealth(t *testing.T) {
+	s := healthServer()
+	defer s.Close()
+
+	p := retryPlugin(t, s)
+	p.HealthProbes = defaultHealthProbes
+	stat := map[string]interface{}{}
+	p.probeHealth(context.Background(), stat)
+
+	wants := map[string]interface{}{
+		"health_live_up":         uint64(1),
+		"health_ready_up":        uint64(1),
+		"health_cluster_up":      uint64(0),
+		"health_cluster_read_up": uint64(1),
+		"health_maintenance_up":  uint64(0),
+		"health_write_quorum":    uint64(3),
+	}
+	for k, v := range wants {
+		if !reflect.DeepEqual(stat[k], v) {
+			t.Fatalf("%s: got=%v, want=%v", k, stat[k], v)
+		}
+	}
+	if _, ok := stat["health_live_latency_ms"].(float64); !ok {
+		t.Fatal("health_live_latency_ms is not stored")
+	}
+
+	status, msg := evaluateChecks(stat, nil, testCheckThresholds)
+	if status != CheckCritical || !strings.Contains(msg, "cluster probe failed (CRITICAL)") || !strings.Contains(msg, "maintenance probe failed (WARNING)") {
+		t.Fatalf("got=%s (%s)", status, msg)
+	}
+}
+
+func TestProbeHealthSelected(t *testing.T) {
+	s := healthServer()
+	defer s.Close()
+
+	p := retryPlugin(t, s)
+	p.HealthProbes = []string{"live"}
+	stat := map[string]interface{}{}
+	p.probeHealth(context.Background(), stat)
+
+	if len(stat) != 2 {
+		t.Fatalf("got=%v, want only the live probe", stat)
+	}
+	graphs := p.graphDefinitionHealth("Minio")
+	if len(graphs["health.up"].Metrics) != 1 {
+		t.Fatalf("got=%v, want only the live probe graphed", graphs["health.up"].Metrics)
+	}
+}
+
+func TestProbeHealthOutOfTime(t *testing.T) {
+	s := healthServer()
+	defer s.Close()
+
+	p := retryPlugin(t, s)
+	p.HealthProbes = defaultHealthProbes
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	stat := map[string]interface{}{}
+	p.probeHealth(ctx, stat)
+
+	if len(stat) != 0 {
+		t.Fatalf("got=%v, want no probe reported down after the deadline", stat)
+	}
+}
@@ -0,0 +1,144 @@
+package mpminio
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// requestDurationFamily is the v1 histogram of HTTP request durations by request type
+const requestDurationFamily = "minio_http_requests_duration_seconds"
+
+// latencyQuantiles are estimated per request type and keyed by their metric names
+var latencyQuantiles = []struct {
+	name string
+	q    float64
+}{
+	{"p50", 0.5},
+	{"p90", 0.9},
+	{"p99", 0.99},
+}
+
+// bucketCounts are the cumulative counts of a histogram keyed by upper bounds as exposed, e.g. "0.005" and "+Inf"
+type bucketCounts map[string]uint64
+
+// histogram is a cumulative histogram of request durations in seconds
+type histogram struct {
+	Buckets bucketCounts `json:"buckets"`
+	Sum     float64      `json:"sum"`
+	Count   uint64       `json:"count"`
+}
+
+// histograms are keyed by request type
+type histograms map[string]histogram
+
+// delta returns the observations since prev. Histograms reset by a restart are taken as they are.
+func (h histogram) delta(prev histogram) histogram {
+	if h.Count < prev.Count || h.Sum < prev.Sum {
+		return h
+	}
+	return histogram{
+		Buckets: h.Buckets.delta(prev.Buckets),
+		Sum:     h.Sum - prev.Sum,
+		Count:   h.Count - prev.Count,
+	}
+}
+
+// delta returns the counts observed since prev. Counters reset by a restart are taken as they are.
+func (b bucketCounts) delta(prev bucketCounts) bucketCounts {
+	d := bucketCounts{}
+	for le, n := range b {
+		p, ok := prev[le]
+		if !ok {
+			// The bucket layout has changed, so the counts cannot be compared
+			return b
+		}
+		if n < p {
+			return b
+		}
+		d[le] = n - p
+	}
+	return d
+}
+
+// bucketBound is an upper bound of a bucket with its cumulative count
+type bucketBound struct {
+	upper float64
+	count uint64
+}
+
+// sorted returns the buckets ordered by upper bounds, skipping ones failing to parse
+func (b bucketCounts) sorted() []bucketBound {
+	bounds := make([]bucketBound, 0, len(b))
+	for le, n := range b {
+		upper, err := strconv.ParseFloat(le, 64)
+		if err != nil {
+			continue
+		}
+		bounds = append(bounds, bucketBound{upper, n})
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i].upper < bounds[j].upper })
+	return bounds
+}
+
+// quantile estimates the q-quantile by linear interpolation within the bucket it falls in,
+// the same way as histogram_quantile of Prometheus. It reports false without any observation.
+func (b bucketCounts) quantile(q float64) (float64, bool) {
+	bounds := b.sorted()
+	if len(bounds) < 2 || !math.IsInf(bounds[len(bounds)-1].upper, 1) {
+		return 0, false
+	}
+	total := bounds[len(bounds)-1].count
+	if total == 0 {
+		return 0, false
+	}
+
+	rank := q * float64(total)
+	i := sort.Search(len(bounds), func(i int) bool { return float64(bounds[i].count) >= rank })
+	if i == len(bounds)-1 {
+		// Nothing is known above the highest finite bound
+		return bounds[len(bounds)-2].upper, true
+	}
+	if i == 0 && bounds[0].upper <= 0 {
+		return bounds[0].upper, true
+	}
+
+	var start float64
+	var below uint64
+	if i > 0 {
+		start = bounds[i-1].upper
+		below = bounds[i-1].count
+	}
+	end := bounds[i].upper
+	count := bounds[i].count - below
+	if count == 0 {
+		return end, true
+	}
+	return start + (end-start)*(rank-float64(below))/float64(count), true
+}
+
+// calcLatencies stores the latency quantiles and average in milliseconds, and the request rate
+// of each request type observed during elapsed since the previous run.
+// Nothing is stored on the first run as the histograms are cumulative.
+func calcLatencies(stat map[string]interface{}, current, previous histograms, elapsed time.Duration) {
+	for rt, h := range current {
+		prev, ok := previous[rt]
+		if !ok {
+			continue
+		}
+		d := h.delta(prev)
+		key := sanitizeKey(rt)
+		for _, lq := range latencyQuantiles {
+			if v, ok := d.Buckets.quantile(lq.q); ok {
+				stat["http_latency."+key+"."+lq.name] = v * 1000
+			}
+		}
+		if d.Count > 0 {
+			stat["http_latency."+key+".avg"] = d.Sum / float64(d.Count) * 1000
+		}
+		if elapsed > 0 {
+			stat["http_request_rate."+key] = float64(d.Count) / elapsed.Seconds()
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package mpminio
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBucketCountsQuantile(t *testing.T) {
+	tests := []struct {
+		buckets bucketCounts
+		q       float64
+		want    float64
+		ok      bool
+	}{
+		// interpolated from zero within the first bucket
+		{bucketCounts{"0.1": 10, "0.5": 20, "1": 20, "+Inf": 20}, 0.5, 0.1, true},
+		{bucketCounts{"0.1": 10, "0.5": 20, "1": 20, "+Inf": 20}, 0.9, 0.42, true},
+		{bucketCounts{"0.1": 10, "0.5": 20, "1": 20, "+Inf": 20}, 0.99, 0.492, true},
+		// the highest finite bound when the rank falls in +Inf
+		{bucketCounts{"0.1": 1, "+Inf": 10}, 0.5, 0.1, true},
+		// no observation
+		{bucketCounts{"0.1": 0, "+Inf": 0}, 0.5, 0, false},
+		// without +Inf
+		{bucketCounts{"0.1": 1, "0.5": 2}, 0.5, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := tt.buckets.quantile(tt.q)
+		if ok != tt.ok || math.Abs(got-tt.want) > 1e-9 {
+			t.Fatalf("%v q=%v: got=%v/%v, want=%v/%v", tt.buckets, tt.q, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestBucketCountsDelta(t *testing.T) {
+	cur := bucketCounts{"0.1": 15, "0.5": 30, "+Inf": 40}
+
+	d := cur.delta(bucketCounts{"0.1": 5, "0.5": 10, "+Inf": 20})
+	if d["0.1"] != 10 || d["0.5"] != 20 || d["+Inf"] != 20 {
+		t.Fatalf("got=%v", d)
+	}
+
+	// restarted
+	d = cur.delta(bucketCounts{"0.1": 50, "0.5": 60, "+Inf": 70})
+	if d["+Inf"] != 40 {
+		t.Fatalf("got=%v, want the current counts", d)
+	}
+}
+
+func TestHistogramDelta(t *testing.T) {
+	cur := histogram{Buckets: bucketCounts{"0.1": 15, "+Inf": 40}, Sum: 3, Count: 40}
+
+	d := cur.delta(histogram{Buckets: bucketCounts{"0.1": 5, "+Inf": 20}, Sum: 1, Count: 20})
+	if d.Count != 20 || math.Abs(d.Sum-2) > 1e-9 || d.Buckets["0.1"] != 10 {
+		t.Fatalf("got=%v", d)
+	}
+
+	// restarted
+	d = cur.delta(histogram{Buckets: bucketCounts{"0.1": 50, "+Inf": 70}, Sum: 5, Count: 70})
+	if !reflect.DeepEqual(d, cur) {
+		t.Fatalf("got=%v, want the current histogram", d)
+	}
+}
+
+func TestCalcLatencies(t *testing.T) {
+	current := histograms{
+		"GET": {Buckets: bucketCounts{"0.1": 15, "0.5": 30, "+Inf": 40}, Sum: 5, Count: 40},
+		"PUT": {Buckets: bucketCounts{"0.1": 1, "+Inf": 1}, Sum: 0.05, Count: 1},
+	}
+	previous := histograms{
+		"GET": {Buckets: bucketCounts{"0.1": 5, "0.5": 10, "+Inf": 20}, Sum: 1, Count: 20},
+	}
+
+	stat := map[string]interface{}{}
+	calcLatencies(stat, current, previous, 10*time.Second)
+	wants := map[string]float64{
+		"http_latency.GET.p50":  100,
+		"http_latency.GET.avg":  200,
+		"http_request_rate.GET": 2,
+	}
+	for k, want := range wants {
+		if v, ok := stat[k].(float64); !ok || math.Abs(v-want) > 1e-9 {
+			t.Fatalf("%s: got=%v, want=%v", k, stat[k], want)
+		}
+	}
+	if _, ok := stat["http_latency.PUT.p50"]; ok {
+		t.Fatal("PUT has no previous counts")
+	}
+}
+
+func TestFetchMetricsLatencies(t *testing.T) {
+	s := SetupMockServer(t)
+	defer s.Server.Close()
+	dir, err := ioutil.TempDir("", "mackerel-plugin-minio-latency")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s.plugin.Tempfile = filepath.Join(dir, "mackerel-plugin-minio")
+
+	state := &pluginState{
+		Histograms: histograms{
+			"GET": {Buckets: bucketCounts{"0.001": 0, "0.003": 0, "0.005": 0, "0.1": 0, "0.5": 0, "1": 0, "+Inf": 0}},
+		},
+		HistogramsAt: time.Now().Add(-time.Minute).Unix(),
+	}
+	if err := state.save(s.plugin.stateFile()); err != nil {
+		t.Fatal(err)
+	}
+
+	stat, err := s.plugin.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 9334 out of 18666 GET requests finished in 1ms
+	if v, ok := stat["http_latency.GET.p50"].(float64); !ok || math.Abs(v-1) > 1e-3 {
+		t.Fatalf("got=%v, want=1", stat["http_latency.GET.p50"])
+	}
+
+	if _, ok := stat["http_request_rate.GET"]; !ok {
+		t.Fatal("http_request_rate.GET is not stored")
+	}
+
+	state, err = loadState(s.plugin.stateFile())
+	if err != nil || state.Histograms["GET"].Count != 18666 {
+		t.Fatalf("got=%v/%v, want the GET counts saved", state.Histograms["GET"], err)
+	}
+}
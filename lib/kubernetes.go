@@ -0,0 +1,247 @@
+package mpminio
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// serviceAccountDir holds the credentials of the pod to talk to Kubernetes API in a cluster
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// kubeconfig is the part of a kubeconfig file to connect to Kubernetes API
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string `yaml:"token"`
+			TokenFile             string `yaml:"tokenFile"`
+			ClientCertificate     string `yaml:"client-certificate"`
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKey             string `yaml:"client-key"`
+			ClientKeyData         string `yaml:"client-key-data"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster   string `yaml:"cluster"`
+			User      string `yaml:"user"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+// kubeClient requests Kubernetes API
+type kubeClient struct {
+	server string
+	token  string
+	// namespace is the one of the context or the service account, used when none is given
+	namespace string
+	// caFile is the CA certificate of the service account, empty out of a cluster
+	caFile string
+	client *http.Client
+}
+
+// newKubeClient connects to Kubernetes API with the kubeconfig file, or with the service account
+// when file is empty in a cluster. Otherwise $KUBECONFIG or ~/.kube/config is used.
+func newKubeClient(file string) (*kubeClient, error) {
+	if file == "" {
+		if os.Getenv("KUBERNETES_SERVICE_HOST") != "" && os.Getenv("KUBECONFIG") == "" {
+			return inClusterClient(serviceAccountDir)
+		}
+		file = defaultKubeconfig()
+	}
+	return kubeconfigClient(file)
+}
+
+// defaultKubeconfig returns the kubeconfig file kubectl uses by default
+func defaultKubeconfig() string {
+	if f := os.Getenv("KUBECONFIG"); f != "" {
+		// only the first of the files is read
+		return filepath.SplitList(f)[0]
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".kube", "config")
+}
+
+// inClusterClient connects with the service account mounted in dir
+func inClusterClient(dir string) (*kubeClient, error) {
+	token, err := ioutil.ReadFile(filepath.Join(dir, "token"))
+	if err != nil {
+		return nil, fmt.Errorf("reading service account token failed: %v", err)
+	}
+	caFile := filepath.Join(dir, "ca.crt")
+	ca, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading service account CA certificate failed: %v", err)
+	}
+	namespace, _ := ioutil.ReadFile(filepath.Join(dir, "namespace"))
+
+	config := &tls.Config{}
+	if config.RootCAs, err = certPool(ca); err != nil {
+		return nil, err
+	}
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	return &kubeClient{
+		server:    "https://" + net.JoinHostPort(host, port),
+		token:     strings.TrimSpace(string(token)),
+		namespace: strings.TrimSpace(string(namespace)),
+		caFile:    caFile,
+		client:    &http.Client{Transport: &http.Transport{TLSClientConfig: config}},
+	}, nil
+}
+
+// kubeconfigClient connects with the current context of the kubeconfig file. Exec and auth provider
+// plugins are not supported; tokens and client certificates are.
+func kubeconfigClient(file string) (*kubeClient, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading kubeconfig failed: %v", err)
+	}
+	var c kubeconfig
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("parsing kubeconfig failed: %v", err)
+	}
+
+	k := &kubeClient{namespace: "default"}
+	config := &tls.Config{}
+	found := false
+	for _, ctx := range c.Contexts {
+		if ctx.Name != c.CurrentContext {
+			continue
+		}
+		found = true
+		if ctx.Context.Namespace != "" {
+			k.namespace = ctx.Context.Namespace
+		}
+		for _, cl := range c.Clusters {
+			if cl.Name != ctx.Context.Cluster {
+				continue
+			}
+			k.server = strings.TrimSuffix(cl.Cluster.Server, "/")
+			config.InsecureSkipVerify = cl.Cluster.InsecureSkipTLSVerify
+			ca, err := kubeconfigData(cl.Cluster.CertificateAuthorityData, cl.Cluster.CertificateAuthority)
+			if err != nil {
+				return nil, err
+			}
+			if ca != nil {
+				if config.RootCAs, err = certPool(ca); err != nil {
+					return nil, err
+				}
+			}
+		}
+		for _, u := range c.Users {
+			if u.Name != ctx.Context.User {
+				continue
+			}
+			k.token = u.User.Token
+			if u.User.TokenFile != "" {
+				token, err := ioutil.ReadFile(u.User.TokenFile)
+				if err != nil {
+					return nil, fmt.Errorf("reading token file failed: %v", err)
+				}
+				k.token = strings.TrimSpace(string(token))
+			}
+			cert, err := kubeconfigData(u.User.ClientCertificateData, u.User.ClientCertificate)
+			if err != nil {
+				return nil, err
+			}
+			key, err := kubeconfigData(u.User.ClientKeyData, u.User.ClientKey)
+			if err != nil {
+				return nil, err
+			}
+			if cert != nil && key != nil {
+				pair, err := tls.X509KeyPair(cert, key)
+				if err != nil {
+					return nil, fmt.Errorf("loading client certificate failed: %v", err)
+				}
+				config.Certificates = []tls.Certificate{pair}
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("context %q is not found in %s", c.CurrentContext, file)
+	}
+	if k.server == "" {
+		return nil, fmt.Errorf("no server of context %q is found in %s", c.CurrentContext, file)
+	}
+	k.client = &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+	return k, nil
+}
+
+// kubeconfigData returns the base64 encoded data, or the content of the file
+func kubeconfigData(data, file string) ([]byte, error) {
+	if data != "" {
+		b, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, fmt.Errorf("decoding kubeconfig data failed: %v", err)
+		}
+		return b, nil
+	}
+	if file == "" {
+		return nil, nil
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s failed: %v", file, err)
+	}
+	return b, nil
+}
+
+// certPool returns a pool of the PEM encoded certificates
+func certPool(pem []byte) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no valid CA certificate of Kubernetes API is found")
+	}
+	return pool, nil
+}
+
+// get requests the path of Kubernetes API and decodes the JSON response into v
+func (k *kubeClient) get(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, k.server+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if k.token != "" {
+		req.Header.Set("Authorization", "Bearer "+k.token)
+	}
+	resp, err := k.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("GET %s failed: %v", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", path, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("parsing %s failed: %v", path, err)
+	}
+	return nil
+}
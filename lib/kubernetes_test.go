@@ -0,0 +1,118 @@
+package mpminio
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeKubeconfig writes a kubeconfig of the server with a token into dir
+func writeKubeconfig(t *testing.T, dir, server string) string {
+	file := filepath.Join(dir, "kubeconfig")
+	content := `apiVersion: v1
+kind: Config
+current-context: tenant
+clusters:
+- name: other
+  cluster:
+    server: https://other.example.net
+- name: fake
+  cluster:
+    server: ` + server + `/
+contexts:
+- name: other
+  context:
+    cluster: other
+    user: other
+- name: tenant
+  context:
+    cluster: fake
+    user: plugin
+    namespace: minio-tenant
+users:
+- name: other
+  user:
+    token: wrong
+- name: plugin
+  user:
+    token: kube-token
+`
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestKubeconfigClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mackerel-plugin-minio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer kube-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"kind": "Namespace"}`))
+	}))
+	defer s.Close()
+
+	k, err := kubeconfigClient(writeKubeconfig(t, dir, s.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.server != s.URL || k.namespace != "minio-tenant" {
+		t.Fatalf("got=%s %s, want the server and namespace of the current context", k.server, k.namespace)
+	}
+	var v struct{ Kind string }
+	if err := k.get(context.Background(), "/api/v1/namespaces/minio-tenant", &v); err != nil || v.Kind != "Namespace" {
+		t.Fatalf("got=%+v (%v)", v, err)
+	}
+
+	missing := filepath.Join(dir, "missing")
+	if err := ioutil.WriteFile(missing, []byte("current-context: missing\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kubeconfigClient(missing); err == nil {
+		t.Fatal("want an error of the missing context")
+	}
+}
+
+func TestInClusterClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mackerel-plugin-minio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := inClusterClient(dir); err == nil {
+		t.Fatal("want an error without the service account")
+	}
+
+	s := httptest.NewTLSServer(http.NotFoundHandler())
+	defer s.Close()
+	writePEM(t, dir, "ca.crt", "CERTIFICATE", s.Certificate().Raw)
+	for name, content := range map[string]string{"token": "sa-token\n", "namespace": "minio-tenant"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Setenv("KUBERNETES_SERVICE_HOST", "10.96.0.1")
+	os.Setenv("KUBERNETES_SERVICE_PORT", "443")
+	defer os.Unsetenv("KUBERNETES_SERVICE_HOST")
+	defer os.Unsetenv("KUBERNETES_SERVICE_PORT")
+
+	k, err := inClusterClient(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.server != "https://10.96.0.1:443" || k.token != "sa-token" || k.namespace != "minio-tenant" || k.caFile != filepath.Join(dir, "ca.crt") {
+		t.Fatalf("got=%+v", k)
+	}
+}
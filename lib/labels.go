@@ -0,0 +1,261 @@
+package mpminio
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	aggregateSum = "sum"
+	aggregateMax = "max"
+)
+
+// LabelRule decides how series of the families matching Family are flattened into metric keys.
+// Family is either a family name or a pattern such as "minio_node_drive_*".
+type LabelRule struct {
+	Family string
+	// Keep lists the labels turned into key segments. Empty keeps every label which is not dropped.
+	Keep []string
+	// Drop lists the labels left out of the key. "*" drops all of them.
+	Drop []string
+	// Aggregate merges series flattened into the same key, either "sum" (default) or "max"
+	Aggregate string
+	// Key formats the metric key from {family} and {labels}, the joined segments.
+	// By default it is "{family}_{labels}", or just "{family}" without any segment.
+	Key string
+}
+
+// defaultLabelOrder puts the labels distinguishing series in graphs first
+var defaultLabelOrder = []string{"api", "name", "code", "request_type", "bucket", "drive", "server"}
+
+// defaultDroppedLabels identify where a series comes from rather than what it is
+var defaultDroppedLabels = []string{"server", "pool_index", "set_index", "drive_index", "type"}
+
+// builtinLabelRules keep the labels graphed by GraphDefinition and sum up the others.
+// Rules given by users take precedence.
+var builtinLabelRules = []LabelRule{
+	// Series found at scrape time are graphed with wildcards
+	{Family: "promhttp_metric_handler_requests_total", Keep: []string{"code"}, Key: "http.request_counts.{labels}"},
+	{Family: "minio_s3_requests_total", Keep: []string{"api"}, Key: "s3.requests.{labels}"},
+	{Family: "minio_s3_requests_errors_total", Keep: []string{"api"}, Key: "s3.errors.{labels}"},
+	{Family: "minio_api_requests_total", Keep: []string{"name"}, Key: "api_requests.count.{labels}"},
+	{Family: "minio_api_requests_errors_total", Keep: []string{"name"}, Key: "api_requests.errors.{labels}"},
+	{Family: "minio_node_drive_total_bytes", Keep: []string{"drive"}, Key: "node.drives.{labels}.total_bytes"},
+	{Family: "minio_node_drive_used_bytes", Keep: []string{"drive"}, Key: "node.drives.{labels}.used_bytes"},
+	{Family: "minio_node_drive_free_bytes", Keep: []string{"drive"}, Key: "node.drives.{labels}.free_bytes"},
+	{Family: "minio_system_drive_total_bytes", Keep: []string{"drive"}, Key: "system_drive.drives.{labels}.total_bytes"},
+	{Family: "minio_system_drive_used_bytes", Keep: []string{"drive"}, Key: "system_drive.drives.{labels}.used_bytes"},
+	{Family: "minio_system_drive_free_bytes", Keep: []string{"drive"}, Key: "system_drive.drives.{labels}.free_bytes"},
+	// Other series of drives are summed up into node totals
+	{Family: "minio_node_drive_*", Drop: []string{"*"}},
+	{Family: "minio_system_drive_*", Drop: []string{"*"}},
+	{Family: "minio_api_requests_inflight_total", Drop: []string{"*"}},
+	{Family: "minio_api_requests_traffic_*", Drop: []string{"*"}},
+	{Family: "minio_s3_requests_*", Keep: []string{"api"}},
+	{Family: "minio_api_requests_*", Keep: []string{"name"}},
+	{Family: "minio_bucket_usage_total_bytes", Keep: []string{"bucket"}, Key: "bucket_size.{labels}.total_bytes"},
+	{Family: "minio_bucket_usage_object_total", Keep: []string{"bucket"}, Key: "bucket_objects.{labels}.objects"},
+	{Family: "minio_bucket_replication_last_hour_failed_bytes", Keep: []string{"bucket"}, Key: "bucket_replication_bytes.{labels}.last_hour_failed"},
+	{Family: "minio_bucket_replication_total_failed_bytes", Keep: []string{"bucket"}, Key: "bucket_replication_bytes.{labels}.total_failed"},
+	{Family: "minio_bucket_replication_sent_bytes", Keep: []string{"bucket"}, Key: "bucket_replication_bytes.{labels}.sent"},
+	{Family: "minio_bucket_replication_received_bytes", Keep: []string{"bucket"}, Key: "bucket_replication_bytes.{labels}.received"},
+	{Family: "minio_bucket_replication_last_hour_failed_count", Keep: []string{"bucket"}, Key: "bucket_replication_count.{labels}.last_hour_failed"},
+	{Family: "minio_bucket_replication_total_failed_count", Keep: []string{"bucket"}, Key: "bucket_replication_count.{labels}.total_failed"},
+}
+
+// flattener turns label sets into sanitized, stable key segments
+type flattener struct {
+	order map[string]int
+	// rules given by users are looked up before the built-in ones
+	rules [][]LabelRule
+}
+
+// flattener combines the configured label order and rules with the built-in ones
+func (m MinioPlugin) flattener() *flattener {
+	order := m.LabelOrder
+	if len(order) == 0 {
+		order = defaultLabelOrder
+	}
+	f := &flattener{order: map[string]int{}}
+	for i, l := range order {
+		f.order[l] = i
+	}
+	f.rules = [][]LabelRule{m.LabelRules, builtinLabelRules}
+	return f
+}
+
+// rule returns the rule of the family. An exact match wins over patterns, and
+// the longest pattern wins among them. Without any match, the default rule is returned.
+func (f *flattener) rule(family string) LabelRule {
+	for _, rules := range f.rules {
+		if r := matchRule(rules, family); r != nil {
+			return *r
+		}
+	}
+	return LabelRule{Family: family, Drop: defaultDroppedLabels}
+}
+
+// matchRule returns the rule best matching the family, or nil
+func matchRule(rules []LabelRule, family string) *LabelRule {
+	var best *LabelRule
+	for i := range rules {
+		r := &rules[i]
+		if r.Family == family {
+			return r
+		}
+		if ok, _ := path.Match(r.Family, family); ok && (best == nil || len(r.Family) > len(best.Family)) {
+			best = r
+		}
+	}
+	return best
+}
+
+// key returns the metric key of the series and how to merge series sharing it
+func (f *flattener) key(family string, labels map[string]string) (string, string) {
+	r := f.rule(family)
+
+	names := []string{}
+	for name, value := range labels {
+		if value != "" && r.keeps(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		oi, iok := f.order[names[i]]
+		oj, jok := f.order[names[j]]
+		switch {
+		case iok && jok:
+			return oi < oj
+		case iok != jok:
+			return iok
+		}
+		return names[i] < names[j]
+	})
+
+	segments := make([]string, len(names))
+	for i, name := range names {
+		segments[i] = sanitizeKey(labels[name])
+	}
+	joined := strings.Join(segments, "_")
+
+	format := r.Key
+	if format == "" {
+		format = "{family}_{labels}"
+		if joined == "" {
+			format = "{family}"
+		}
+	}
+	return strings.NewReplacer("{family}", family, "{labels}", joined).Replace(format), r.Aggregate
+}
+
+// keeps reports whether the label is turned into a key segment
+func (r LabelRule) keeps(label string) bool {
+	for _, d := range r.Drop {
+		if d == "*" || d == label {
+			return false
+		}
+	}
+	if len(r.Keep) == 0 {
+		return true
+	}
+	for _, k := range r.Keep {
+		if k == label {
+			return true
+		}
+	}
+	return false
+}
+
+// aggregate merges value into the one already stored as key
+func (s *Stat) aggregate(key string, value interface{}, how string) {
+	prev, ok := (*s)[key]
+	if !ok {
+		(*s)[key] = value
+		return
+	}
+
+	// Keep the type when both are integers, as metric types are either float or uint
+	if p, ok := prev.(uint64); ok {
+		if v, ok := value.(uint64); ok {
+			if how == aggregateMax {
+				if v > p {
+					(*s)[key] = v
+				}
+				return
+			}
+			(*s)[key] = p + v
+			return
+		}
+	}
+
+	p, _ := toFloat(prev)
+	v, _ := toFloat(value)
+	if how == aggregateMax {
+		if v > p {
+			(*s)[key] = v
+		} else {
+			(*s)[key] = p
+		}
+		return
+	}
+	(*s)[key] = p + v
+}
+
+// ParseLabelRule parses a rule formatted as "<family>:<option>=<value>[;<option>=<value>...]".
+// Options are keep and drop taking comma separated labels, agg taking sum or max, and key.
+// e.g. "minio_node_drive_*:keep=drive;agg=max"
+func ParseLabelRule(s string) (LabelRule, error) {
+	i := strings.Index(s, ":")
+	if i <= 0 {
+		return LabelRule{}, fmt.Errorf("label rule %q lacks a family", s)
+	}
+	r := LabelRule{Family: s[:i]}
+
+	for _, opt := range strings.Split(s[i+1:], ";") {
+		if opt == "" {
+			continue
+		}
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 {
+			return LabelRule{}, fmt.Errorf("label rule %q has an invalid option %q", s, opt)
+		}
+		switch kv[0] {
+		case "keep":
+			r.Keep = strings.Split(kv[1], ",")
+		case "drop":
+			r.Drop = strings.Split(kv[1], ",")
+		case "agg":
+			if kv[1] != aggregateSum && kv[1] != aggregateMax {
+				return LabelRule{}, fmt.Errorf("label rule %q has an unknown aggregation %q", s, kv[1])
+			}
+			r.Aggregate = kv[1]
+		case "key":
+			r.Key = kv[1]
+		default:
+			return LabelRule{}, fmt.Errorf("label rule %q has an unknown option %q", s, kv[0])
+		}
+	}
+	return r, nil
+}
+
+// labelRulesFlag collects label rules given by repeated flags
+type labelRulesFlag []LabelRule
+
+func (f *labelRulesFlag) String() string {
+	rules := make([]string, len(*f))
+	for i, r := range *f {
+		rules[i] = r.Family
+	}
+	return strings.Join(rules, ",")
+}
+
+func (f *labelRulesFlag) Set(s string) error {
+	r, err := ParseLabelRule(s)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, r)
+	return nil
+}
@@ -0,0 +1,94 @@
+package mpminio
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFlattenerKey(t *testing.T) {
+	p := MinioPlugin{
+		LabelRules: []LabelRule{
+			{Family: "minio_node_drive_*", Keep: []string{"drive"}, Aggregate: aggregateMax},
+			{Family: "minio_heal_*", Drop: []string{"*"}},
+		},
+	}
+	fl := p.flattener()
+
+	tests := []struct {
+		family string
+		labels map[string]string
+		want   string
+		how    string
+	}{
+		// no labels
+		{"go_goroutines", nil, "go_goroutines", ""},
+		// v1 status codes are graphed with wildcards
+		{"promhttp_metric_handler_requests_total", map[string]string{"code": "200"}, "http.request_counts.200", ""},
+		// default order first, then alphabetical, sanitized and without server
+		{"minio_custom_total", map[string]string{"zone": "a", "disk": "/data1", "api": "getobject", "server": "minio1:9000"}, "minio_custom_total_getobject__data1_a", ""},
+		// user rules win over the built-in ones
+		{"minio_node_drive_used_bytes", map[string]string{"drive": "/data1", "server": "minio1:9000"}, "minio_node_drive_used_bytes__data1", aggregateMax},
+		{"minio_heal_objects_total", map[string]string{"type": "object"}, "minio_heal_objects_total", ""},
+		// key template
+		{"minio_bucket_usage_object_total", map[string]string{"bucket": "logs.2019", "server": "minio1:9000"}, "bucket_objects.logs_2019.objects", ""},
+	}
+	for _, tt := range tests {
+		got, how := fl.key(tt.family, tt.labels)
+		if got != tt.want || how != tt.how {
+			t.Fatalf("%s: got=%s/%s, want=%s/%s", tt.family, got, how, tt.want, tt.how)
+		}
+	}
+}
+
+func TestFlattenerLabelOrder(t *testing.T) {
+	p := MinioPlugin{LabelOrder: []string{"server", "disk"}}
+	got, _ := p.flattener().key("minio_custom_total", map[string]string{"disk": "sda", "server": "minio1"})
+	// server is dropped by default even if ordered
+	if got != "minio_custom_total_sda" {
+		t.Fatalf("got=%s", got)
+	}
+
+	p.LabelRules = []LabelRule{{Family: "minio_custom_total"}}
+	got, _ = p.flattener().key("minio_custom_total", map[string]string{"disk": "sda", "server": "minio1"})
+	if got != "minio_custom_total_minio1_sda" {
+		t.Fatalf("got=%s", got)
+	}
+}
+
+func TestStatAggregate(t *testing.T) {
+	s := make(Stat)
+	s.aggregate("a", uint64(1), "")
+	s.aggregate("a", uint64(2), "")
+	s.aggregate("b", float64(1.5), aggregateMax)
+	s.aggregate("b", float64(0.5), aggregateMax)
+	s.aggregate("c", uint64(1), aggregateSum)
+	s.aggregate("c", float64(0.5), aggregateSum)
+
+	want := Stat{"a": uint64(3), "b": float64(1.5), "c": float64(1.5)}
+	if !reflect.DeepEqual(s, want) {
+		t.Fatalf("got=%v, want=%v", s, want)
+	}
+}
+
+func TestParseLabelRule(t *testing.T) {
+	got, err := ParseLabelRule("minio_node_drive_*:keep=drive,server;drop=pool_index;agg=max;key={family}.{labels}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := LabelRule{
+		Family:    "minio_node_drive_*",
+		Keep:      []string{"drive", "server"},
+		Drop:      []string{"pool_index"},
+		Aggregate: aggregateMax,
+		Key:       "{family}.{labels}",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got=%+v, want=%+v", got, want)
+	}
+
+	for _, s := range []string{"keep=drive", "minio:keep", "minio:agg=avg", "minio:only=drive"} {
+		if _, err := ParseLabelRule(s); err == nil {
+			t.Fatalf("%s: got no error", s)
+		}
+	}
+}
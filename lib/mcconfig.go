@@ -0,0 +1,89 @@
+package mpminio
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// McAlias is an alias of the mc configuration, i.e. a server with its credentials
+type McAlias struct {
+	URL       string `json:"url"`
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"secretKey"`
+}
+
+// mcConfig is the mc configuration file. Versions before 10 name the aliases hosts.
+type mcConfig struct {
+	Aliases map[string]McAlias `json:"aliases"`
+	Hosts   map[string]McAlias `json:"hosts"`
+}
+
+// defaultMcConfig returns the configuration file mc uses by default, ~/.mc/config.json
+func defaultMcConfig() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".mc", "config.json")
+}
+
+// LoadMcAlias reads the alias from the mc configuration file. The default file is used when file is empty.
+func LoadMcAlias(file, alias string) (McAlias, error) {
+	if file == "" {
+		file = defaultMcConfig()
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return McAlias{}, fmt.Errorf("reading mc config failed: %v", err)
+	}
+	var c mcConfig
+	if err := json.Unmarshal(b, &c); err != nil {
+		return McAlias{}, fmt.Errorf("parsing mc config failed: %v", err)
+	}
+
+	a, ok := c.Aliases[alias]
+	if !ok {
+		if a, ok = c.Hosts[alias]; !ok {
+			return McAlias{}, fmt.Errorf("alias %q is not found in %s", alias, file)
+		}
+	}
+	if u, err := url.Parse(a.URL); err != nil || u.Hostname() == "" {
+		return McAlias{}, fmt.Errorf("invalid URL %q of alias %q", a.URL, alias)
+	}
+	return a, nil
+}
+
+// Apply fills in the endpoint and credentials of m from the alias, except the ones in set,
+// which are the names of flags given explicitly
+func (a McAlias) Apply(m *MinioPlugin, set map[string]bool) {
+	u, err := url.Parse(a.URL)
+	if err != nil {
+		return
+	}
+	if !set["scheme"] {
+		m.Scheme = u.Scheme
+	}
+	if !set["host"] {
+		m.Host = u.Hostname()
+	}
+	if !set["port"] {
+		switch {
+		case u.Port() != "":
+			m.Port = u.Port()
+		case u.Scheme == "https":
+			m.Port = "443"
+		default:
+			m.Port = "80"
+		}
+	}
+	if a.AccessKey != "" && !set["access-key"] && !set["credentials-file"] {
+		m.AccessKey = a.AccessKey
+	}
+	if a.SecretKey != "" && !set["secret-key"] && !set["credentials-file"] {
+		m.SecretKey = a.SecretKey
+	}
+}
@@ -0,0 +1,59 @@
+package mpminio
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadMcAlias(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mackerel-plugin-minio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "config.json")
+	content := `{
+	"version": "10",
+	"aliases": {
+		"prod": {"url": "https://minio.example.net", "accessKey": "prod-key", "secretKey": "prod-secret", "api": "s3v4", "path": "auto"},
+		"broken": {"url": "minio", "accessKey": "key", "secretKey": "secret"}
+	}
+}`
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	alias, err := LoadMcAlias(file, "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := MinioPlugin{Scheme: "http", Host: "localhost", Port: "9000"}
+	alias.Apply(&m, map[string]bool{})
+	if m.Scheme != "https" || m.Host != "minio.example.net" || m.Port != "443" || m.AccessKey != "prod-key" || m.SecretKey != "prod-secret" {
+		t.Fatalf("got=%+v, want the endpoint and keys of prod", m)
+	}
+
+	// explicit flags win
+	m = MinioPlugin{Port: "9000", AccessKey: "flag"}
+	alias.Apply(&m, map[string]bool{"port": true, "access-key": true})
+	if m.Port != "9000" || m.AccessKey != "flag" || m.SecretKey != "prod-secret" {
+		t.Fatalf("got=%+v, want the flags kept", m)
+	}
+
+	for _, a := range []string{"broken", "staging"} {
+		if _, err := LoadMcAlias(file, a); err == nil {
+			t.Fatalf("%s: want an error", a)
+		}
+	}
+
+	// mc before version 10 names aliases hosts
+	if err := ioutil.WriteFile(file, []byte(`{"version": "9", "hosts": {"old": {"url": "http://minio1:9000"}}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if alias, err := LoadMcAlias(file, "old"); err != nil || alias.URL != "http://minio1:9000" {
+		t.Fatalf("got=%+v (%v)", alias, err)
+	}
+}
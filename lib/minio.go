@@ -1,28 +1,90 @@
 package mpminio
 
 import (
-	"crypto/tls"
+	"context"
+	"crypto/sha1"
 	"flag"
 	"fmt"
 	"log"
+	"math"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
 	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/prom2json"
 )
 
+const (
+	metricsVersionV1   = "v1"
+	metricsVersionV2   = "v2"
+	metricsVersionV3   = "v3"
+	metricsVersionAuto = "auto"
+	// metricsVersionUnknown is detected when no metrics endpoint responds, to probe again on the next run
+	metricsVersionUnknown = "unknown"
+)
+
 // MinioPlugin contains an endpoint of Minio Server and prefix of Graph definition name
 type MinioPlugin struct {
+	// Name identifies the node among the others instead of its host and port, e.g. a discovered instance
+	Name        string
 	Scheme      string
 	Host        string
 	Port        string
 	MetricsPath string
-	Prefix      string
-	Tempfile    string
+	// MetricsVersion is one of "v1", "v2", "v3" or "auto". Empty means "v1".
+	MetricsVersion string
+	// MetricsGroups are the v3 groups to scrape, e.g. "api/requests"
+	MetricsGroups []string
+	// BearerToken is sent to endpoints protected by MINIO_PROMETHEUS_AUTH_TYPE=jwt.
+	// BearerTokenFile takes precedence and is read on every scrape to follow token rotation.
+	BearerToken     string
+	BearerTokenFile string
+	// AccessKey and SecretKey are used to generate a bearer token when none is given
+	AccessKey string
+	SecretKey string
+	// CACert verifies the server instead of the system roots unless Insecure is set.
+	// ClientCert and ClientKey are presented for mutual TLS, ServerName overrides SNI.
+	CACert     string
+	ClientCert string
+	ClientKey  string
+	ServerName string
+	Insecure   bool
+	// Timeout bounds a whole scrape including every endpoint and retry. Zero means no timeout.
+	Timeout time.Duration
+	// Retries is the number of extra attempts on transient failures, waiting for
+	// RetryBackoff doubled on every retry with jitter.
+	Retries      int
+	RetryBackoff time.Duration
+	// BreakerThreshold consecutive failed runs skip scraping for BreakerCooldown. Zero disables it.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// LabelOrder orders the key segments flattened from labels. LabelRules take
+	// precedence over the built-in rules deciding which labels become segments.
+	LabelOrder []string
+	LabelRules []LabelRule
+	// LatencySLOs are the latency targets of request types to compute Apdex scores and compliance (v1)
+	LatencySLOs []LatencySLO
+	// HealthProbes are the health endpoints called along with scrapes, e.g. "live" and "cluster"
+	HealthProbes []string
+	// ExpectedDrives is the number of drives the node should have, given by ParseServers. Zero disables it.
+	ExpectedDrives int
+	// AvailabilityObjective and LatencyObjective are the percentages of requests expected to
+	// succeed and to be within their latency targets, tracked as error budgets. Zero disables them.
+	AvailabilityObjective float64
+	LatencyObjective      float64
+	Prefix                string
+	Tempfile              string
+
+	// client is shared by the requests of a scrape to reuse connections, or built per request without it
+	client *http.Client
 }
 
 // MetricKeyPrefix interface for PluginWithPrefix
@@ -33,79 +95,421 @@ func (m MinioPlugin) MetricKeyPrefix() string {
 	return m.Prefix
 }
 
-// fetchAllMetrics fetches all Prometeus compatible metrics from the unauthorized endpoint.
+// statusError is returned when the metrics endpoint responds with a non-200 status
+type statusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e *statusError) Error() string {
+	if isAuthError(e) {
+		return fmt.Sprintf("GET request for URL %q was rejected with HTTP status %s: check the bearer token, or set MINIO_PROMETHEUS_AUTH_TYPE=public on the server", e.URL, e.Status)
+	}
+	return fmt.Sprintf("GET request for URL %q returned HTTP status %s", e.URL, e.Status)
+}
+
+// errorList collects failures which did not stop the rest of the metrics from being collected
+type errorList []error
+
+func (e errorList) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// add appends err, flattening nested lists
+func (e *errorList) add(err error) {
+	switch err := err.(type) {
+	case nil:
+	case errorList:
+		*e = append(*e, err...)
+	default:
+		*e = append(*e, err)
+	}
+}
+
+// err returns nil when nothing failed so that callers can compare the result with nil
+func (e errorList) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// isNotFound reports whether err tells that the requested endpoint does not exist
+func isNotFound(err error) bool {
+	se, ok := err.(*statusError)
+	return ok && se.StatusCode == http.StatusNotFound
+}
+
+// isAuthError reports whether err tells that the request lacks valid credentials
+func isAuthError(err error) bool {
+	se, ok := err.(*statusError)
+	return ok && (se.StatusCode == http.StatusUnauthorized || se.StatusCode == http.StatusForbidden)
+}
+
+// fetchAllMetrics fetches all Prometeus compatible metrics from the v1 endpoint.
 // FYI, see https://github.com/minio/cookbook/blob/master/docs/how-to-monitor-minio-with-prometheus.md
-func (m MinioPlugin) fetchAllMetrics() []*prom2json.Family {
-	u := m.metricsEndpoint()
-	mfChan := make(chan *dto.MetricFamily, 1024)
-	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+func (m MinioPlugin) fetchAllMetrics(ctx context.Context) ([]*prom2json.Family, error) {
+	return m.fetchFamilies(ctx, m.MetricsPath)
+}
+
+// fetchFamilies fetches metric families exported on the given path, retrying
+// transient failures as long as they fit in the time budget of ctx
+func (m MinioPlugin) fetchFamilies(ctx context.Context, path string) ([]*prom2json.Family, error) {
+	for retry := 0; ; retry++ {
+		families, err := m.fetchFamiliesOnce(ctx, path)
+		if err == nil || retry >= m.Retries || !retryable(err) || !wait(ctx, m.backoff(retry)) {
+			return families, err
+		}
+	}
+}
+
+func (m MinioPlugin) fetchFamiliesOnce(ctx context.Context, path string) ([]*prom2json.Family, error) {
+	resp, err := m.get(ctx, path)
+	if err != nil {
+		return nil, err
 	}
+	defer resp.Body.Close()
 
+	mfChan := make(chan *dto.MetricFamily, 1024)
+	errChan := make(chan error, 1)
 	go func() {
-		if err := prom2json.FetchMetricFamilies(u.String(), mfChan, transport); err != nil {
-			log.Fatal(err)
-		}
+		errChan <- prom2json.ParseResponse(resp, mfChan)
 	}()
 
 	result := []*prom2json.Family{}
 	for mf := range mfChan {
 		result = append(result, prom2json.NewFamily(mf))
 	}
+	if err := <-errChan; err != nil {
+		return nil, err
+	}
 
-	return result
+	return result, nil
 }
 
-// metricsEndpoint returns the url to Minio metrics exporter
-func (m MinioPlugin) metricsEndpoint() url.URL {
+// get requests the given path and returns the response only when it is 200 OK.
+// The caller must close the response body.
+func (m MinioPlugin) get(ctx context.Context, path string) (*http.Response, error) {
+	u := m.endpoint(path)
+	resp, err := m.do(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &statusError{URL: u.String(), StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	return resp, nil
+}
+
+// do sends a GET request to the url whatever the response status is
+func (m MinioPlugin) do(ctx context.Context, u url.URL) (*http.Response, error) {
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating GET request for URL %q failed: %v", u.String(), err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Add("Accept", acceptHeader)
+
+	client := m.client
+	if client == nil {
+		if client, err = m.newClient(); err != nil {
+			return nil, err
+		}
+		defer client.CloseIdleConnections()
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("executing GET request for URL %q failed: %v", u.String(), err)
+	}
+	return resp, nil
+}
+
+// newClient builds the client with the transport, the certificates and the token read at once
+func (m MinioPlugin) newClient() (*http.Client, error) {
+	transport, err := m.transport()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// acceptHeader is the same as the one prom2json sends to negotiate the exposition format
+const acceptHeader = `application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited;q=0.7,text/plain;version=0.0.4;q=0.3`
+
+// endpoint returns the url to the given path on Minio server
+func (m MinioPlugin) endpoint(path string) url.URL {
 	return url.URL{
 		Scheme: m.Scheme,
-		Host:   fmt.Sprintf("%s:%s", m.Host, m.Port),
-		Path:   m.MetricsPath,
+		Host:   net.JoinHostPort(m.Host, m.Port),
+		Path:   path,
 	}
 }
 
-// FetchMetrics is an interface for mackerelplugin
+// metricsVersion returns the version of metrics API to scrape, probing the server in auto mode
+func (m MinioPlugin) metricsVersion() string {
+	switch m.MetricsVersion {
+	case "", metricsVersionV1:
+		return metricsVersionV1
+	case metricsVersionAuto:
+		ctx, cancel := m.context()
+		defer cancel()
+		return m.detectMetricsVersion(ctx)
+	default:
+		return m.MetricsVersion
+	}
+}
+
+// metricsVersionContext is the same as metricsVersion but probes the server within ctx
+func (m MinioPlugin) metricsVersionContext(ctx context.Context) string {
+	if m.MetricsVersion == metricsVersionAuto {
+		return m.detectMetricsVersion(ctx)
+	}
+	return m.metricsVersion()
+}
+
+// context returns a context bounded by Timeout
+func (m MinioPlugin) context() (context.Context, context.CancelFunc) {
+	if m.Timeout > 0 {
+		return context.WithTimeout(context.Background(), m.Timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// detectMetricsVersion probes the v2 node endpoint and falls back to v1 only
+// when the legacy endpoint is served instead. Current releases are assumed otherwise.
+// Without either endpoint responding, the version is unknown rather than guessed.
+func (m MinioPlugin) detectMetricsVersion(ctx context.Context) string {
+	resp, err := m.get(ctx, metricsPathV2Node)
+	if err == nil {
+		resp.Body.Close()
+		return metricsVersionV2
+	}
+	// The endpoint exists but requires a valid token
+	if isAuthError(err) {
+		return metricsVersionV2
+	}
+	resp, err = m.get(ctx, m.MetricsPath)
+	if err == nil {
+		resp.Body.Close()
+		return metricsVersionV1
+	}
+	if isAuthError(err) {
+		return metricsVersionV1
+	}
+	return metricsVersionUnknown
+}
+
+// FetchMetrics is an interface for mackerelplugin.
+// On partial failures, it returns what could be collected together with an errorList.
 func (m MinioPlugin) FetchMetrics() (map[string]interface{}, error) {
-	families := m.fetchAllMetrics()
+	ctx, cancel := m.context()
+	defer cancel()
+	return m.FetchMetricsContext(ctx)
+}
+
+// FetchMetricsContext is the same as FetchMetrics but stops scraping once ctx is done.
+// Metrics gathered until then, including scrape_success, are still returned.
+func (m MinioPlugin) FetchMetricsContext(ctx context.Context) (map[string]interface{}, error) {
+	stat, _, err := m.fetchMetrics(ctx)
+	return stat, err
+}
+
+// scrapeNode scrapes the node within a deadline of its Timeout. The metrics version of auto mode is
+// probed within the same deadline, and kept in the node for GraphDefinition not to probe it again.
+func scrapeNode(node *MinioPlugin) (map[string]interface{}, error) {
+	ctx, cancel := node.context()
+	defer cancel()
+	stat, version, err := node.fetchMetrics(ctx)
+	node.MetricsVersion = version
+	return stat, err
+}
+
+// fetchMetrics is FetchMetricsContext returning the metrics version scraped as well
+func (m MinioPlugin) fetchMetrics(ctx context.Context) (map[string]interface{}, string, error) {
+	start := time.Now()
+	var errs errorList
+
+	state, err := loadState(m.stateFile())
+	errs.add(err)
+	if state.Breaker.isOpen(start) {
+		stat := map[string]interface{}{
+			"breaker_open":                 uint64(1),
+			"breaker_consecutive_failures": uint64(state.Breaker.ConsecutiveFailures),
+		}
+		setScrapeSuccess(stat, false)
+		errs.add(fmt.Errorf("scraping is skipped until %s after %d consecutive failures",
+			time.Unix(state.Breaker.OpenUntil, 0).Format(time.RFC3339), state.Breaker.ConsecutiveFailures))
+		// The node is not probed either
+		version := m.MetricsVersion
+		if version == metricsVersionAuto {
+			version = metricsVersionUnknown
+		}
+		return stat, version, errs.err()
+	}
+
+	// Every request of the scrape shares the connections. Failures are reported by the requests.
+	if client, err := m.newClient(); err == nil {
+		m.client = client
+		defer client.CloseIdleConnections()
+	}
+
+	var stat map[string]interface{}
+	version := m.metricsVersionContext(ctx)
+	switch version {
+	case metricsVersionV2:
+		stat, err = m.fetchMetricsV2(ctx)
+	case metricsVersionV3:
+		stat, err = m.fetchMetricsV3(ctx)
+	case metricsVersionUnknown:
+		stat = map[string]interface{}{}
+		setScrapeSuccess(stat, false)
+		u := m.endpoint("")
+		err = fmt.Errorf("metrics version of %s is unknown since no metrics endpoint responded", u.String())
+	default:
+		stat, err = m.fetchMetricsV1(ctx, state)
+	}
+	errs.add(err)
+	// A node serving anything is not sick, even if a group is missing or a value is broken
+	responded := len(stat) > 1
+
+	m.probeHealth(ctx, stat)
+	calcExpectedDrives(stat, m.ExpectedDrives)
 
+	now := time.Now()
+	stat["scrape_duration_seconds"] = now.Sub(start).Seconds()
+
+	// Counters missing from a partial scrape would look like a restart
+	if requests, errors, ok := availabilityCounts(stat, version); ok && stat["scrape_success"] == uint64(1) {
+		state.Budget.recordAvailability(requests, errors, now)
+	}
+	calcBurnRates(stat, state.Budget, now, m.AvailabilityObjective, m.latencyObjective())
+
+	state.Breaker.record(responded, m.BreakerThreshold, m.BreakerCooldown, now)
+	stat["breaker_consecutive_failures"] = uint64(state.Breaker.ConsecutiveFailures)
+	if state.Breaker.isOpen(now) {
+		stat["breaker_open"] = uint64(1)
+	} else {
+		stat["breaker_open"] = uint64(0)
+	}
+	errs.add(state.save(m.stateFile()))
+
+	return stat, version, errs.err()
+}
+
+// fetchMetricsV1 scrapes the legacy endpoint. Request durations are kept in state for the next run.
+func (m MinioPlugin) fetchMetricsV1(ctx context.Context, state *pluginState) (map[string]interface{}, error) {
 	stat := make(Stat)
+	families, err := m.fetchAllMetrics(ctx)
+	setScrapeSuccess(stat, err == nil)
+	if err != nil {
+		return stat, err
+	}
+
+	var errs errorList
+	fl := m.flattener()
+	hists := histograms{}
 	for _, f := range families {
-		stat.handle(f)
+		errs.add(stat.handle(f, fl, hists))
 	}
+	errs.add(calcMetrics(stat))
+	now := time.Now()
+	calcLatencies(stat, hists, state.Histograms, now.Sub(time.Unix(state.HistogramsAt, 0)))
+	requests, within := calcSLOs(stat, hists, state.Histograms, m.LatencySLOs)
+	state.Budget.recordLatency(requests, requests-within, now)
+	state.Histograms = hists
+	state.HistogramsAt = now.Unix()
 
-	return calcMetrics(stat), nil
+	return stat, errs.err()
 }
 
-// calcMetrics appends manually calculated metrics
-func calcMetrics(stat map[string]interface{}) map[string]interface{} {
-	maxFds, ok := stat["process_max_fds"].(uint64)
-	if !ok {
-		log.Fatal("process_max_fds not found in stat")
+// setScrapeSuccess records whether every endpoint was scraped, regardless of the derived metrics
+func setScrapeSuccess(stat map[string]interface{}, ok bool) {
+	if ok {
+		stat["scrape_success"] = uint64(1)
+	} else {
+		stat["scrape_success"] = uint64(0)
 	}
-	openFds, ok := stat["process_open_fds"].(uint64)
-	if !ok {
-		log.Fatal("process_open_fds not found in stat")
+}
+
+// calcMetrics appends manually calculated metrics. Metrics missing their inputs are skipped and reported.
+func calcMetrics(stat map[string]interface{}) error {
+	var errs errorList
+	errs.add(derivePercentage(stat, "process_fds_percentage", "process_open_fds", "process_max_fds"))
+	errs.add(derivePercentage(stat, "minio_disk_storage_used_percent", "minio_disk_storage_used_bytes", "minio_disk_storage_total_bytes"))
+	return errs.err()
+}
+
+// derivePercentage stores numerator/denominator in percent as key
+func derivePercentage(stat map[string]interface{}, key, numerator, denominator string) error {
+	n, err := derivedInput(stat, key, numerator)
+	if err != nil {
+		return err
 	}
-	stat["process_fds_percentage"] = (float64(openFds) / float64(maxFds)) * 100
+	d, err := derivedDivisor(stat, key, denominator)
+	if err != nil {
+		return err
+	}
+	stat[key] = (n / d) * 100
+	return nil
+}
 
-	maxDiskVolume, ok := stat["minio_disk_storage_total_bytes"].(float64)
-	if !ok {
-		log.Fatal("minio_disk_storage_total_bytes not found in stat")
+// deriveUsedPercentage stores (total-free)/total in percent as key
+func deriveUsedPercentage(stat map[string]interface{}, key, free, total string) error {
+	f, err := derivedInput(stat, key, free)
+	if err != nil {
+		return err
+	}
+	t, err := derivedDivisor(stat, key, total)
+	if err != nil {
+		return err
 	}
-	usedDiskVolume, ok := stat["minio_disk_storage_used_bytes"].(float64)
+	stat[key] = ((t - f) / t) * 100
+	return nil
+}
+
+// derivedInput returns the value of name used to calculate the metric key
+func derivedInput(stat map[string]interface{}, key, name string) (float64, error) {
+	v, ok := toFloat(stat[name])
 	if !ok {
-		log.Fatal("minio_disk_storage_used_bytes not found in stat")
+		return 0, fmt.Errorf("%s is skipped: %s not found in stat", key, name)
 	}
-	stat["minio_disk_storage_used_percent"] = (usedDiskVolume / maxDiskVolume) * 100
+	return v, nil
+}
+
+// derivedDivisor is the same as derivedInput but rejects zero
+func derivedDivisor(stat map[string]interface{}, key, name string) (float64, error) {
+	v, err := derivedInput(stat, key, name)
+	if err == nil && v == 0 {
+		err = fmt.Errorf("%s is skipped: %s is zero", key, name)
+	}
+	return v, err
+}
 
-	return stat
+// toFloat converts a metric value in stat to float64
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case uint64:
+		return float64(n), true
+	}
+	return 0, false
 }
 
 // Stat represents statistics aggregated from the Minio metrics endpoint
 type Stat map[string]interface{}
 
-func (s *Stat) handle(family *prom2json.Family) {
+// handle stores the series of the v1 family, and collects request durations into hists.
+// Series failing to parse are skipped and reported.
+func (s *Stat) handle(family *prom2json.Family, fl *flattener, hists histograms) error {
+	var errs errorList
 	for _, item := range family.Metrics {
 		switch m := item.(type) {
 		case prom2json.Metric:
@@ -117,50 +521,205 @@ func (s *Stat) handle(family *prom2json.Family) {
 			if v, err := strconv.ParseUint(m.Value, 10, 64); err == nil {
 				value = v
 			}
-			(*s)[metricName(family.Name, m.Labels)] = value
+			if value == nil {
+				errs.add(fmt.Errorf("failed to convert %s value of %s", m.Value, family.Name))
+				continue
+			}
+			key, how := fl.key(family.Name, m.Labels)
+			s.aggregate(key, value, how)
+		case prom2json.Summary:
+			errs.add(s.handleSummary(family.Name, m, fl))
 		case prom2json.Histogram:
 			val, ok := m.Labels["request_type"]
 			if !ok {
 				continue
 			}
+			prefix := histogramKey(family.Name) + "." + sanitizeKey(val) + "."
 
 			total, err := strconv.ParseUint(m.Count, 10, 64)
 			if err != nil {
-				log.Fatalf("Failed to convert %s value: %s", m.Count, err)
+				errs.add(fmt.Errorf("failed to convert %s count of %s: %s", m.Count, family.Name, err))
+				continue
 			}
-			(*s)[family.Name+"_"+val+"_total"] = total
+			(*s)[prefix+"total"] = total
 
+			buckets := bucketCounts{"+Inf": total}
 			for k, v := range m.Buckets {
+				// +Inf is the same as the total
+				if k == "+Inf" {
+					continue
+				}
 				n, err := strconv.ParseUint(v, 10, 64)
 				if err != nil {
-					log.Fatalf("Failed to convert %s value: %s", k, err)
+					errs.add(fmt.Errorf("failed to convert %s bucket of %s: %s", k, family.Name, err))
+					continue
 				}
-				k := strings.Replace(k, ".", "_", -1)
-				(*s)[family.Name+"_"+val+"_"+k] = n
+				(*s)[prefix+sanitizeKey(k)] = n
+				buckets[k] = n
+			}
+			if family.Name != requestDurationFamily || hists == nil {
+				continue
+			}
+			sum, err := strconv.ParseFloat(m.Sum, 64)
+			if err != nil {
+				errs.add(fmt.Errorf("failed to convert %s sum of %s: %s", m.Sum, family.Name, err))
+				continue
 			}
+			hists[val] = histogram{Buckets: buckets, Sum: sum, Count: total}
 		}
 	}
+	return errs.err()
+}
+
+// handleSummary stores the quantiles, sum and count of the summary series with keys such as
+// go_gc_duration_seconds_quantile_0_5, go_gc_duration_seconds_sum and go_gc_duration_seconds_count
+// Sums and counts of series sharing a key are aggregated, while quantiles cannot be and the last one wins.
+func (s *Stat) handleSummary(family string, m prom2json.Summary, fl *flattener) error {
+	var errs errorList
+	key, how := fl.key(family, m.Labels)
+
+	for q, v := range m.Quantiles {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			errs.add(fmt.Errorf("failed to convert %s quantile %s of %s: %s", v, q, family, err))
+			continue
+		}
+		// Summaries without any observation report NaN, which cannot be posted
+		if math.IsNaN(f) {
+			continue
+		}
+		(*s)[key+"_quantile_"+sanitizeKey(q)] = f
+	}
+
+	if sum, err := strconv.ParseFloat(m.Sum, 64); err != nil {
+		errs.add(fmt.Errorf("failed to convert %s sum of %s: %s", m.Sum, family, err))
+	} else {
+		s.aggregate(key+"_sum", sum, how)
+	}
+	if count, err := strconv.ParseUint(m.Count, 10, 64); err != nil {
+		errs.add(fmt.Errorf("failed to convert %s count of %s: %s", m.Count, family, err))
+	} else {
+		s.aggregate(key+"_count", count, how)
+	}
+	return errs.err()
+}
+
+// histogramGraphs maps v1 histogram families to their wildcard graphs
+var histogramGraphs = map[string]string{
+	"minio_http_requests_duration_seconds": "http_duration",
 }
 
-// metricName checks labels and returns identical metric name
-func metricName(base string, labels map[string]string) string {
-	if len(labels) == 0 {
-		return base
+// histogramKey returns the key prefix of the histogram family, followed by request types
+func histogramKey(family string) string {
+	if k, ok := histogramGraphs[family]; ok {
+		return k
 	}
+	return family
+}
 
-	// HTTP Status Code
-	if val, ok := labels["code"]; ok {
-		return base + "_" + val
+// sumSeries stores the sum of the series keyed as <prefix><anything><suffix> as key,
+// e.g. totals of drives graphed with wildcards. Nothing is stored without any series.
+func sumSeries(stat map[string]interface{}, key, prefix, suffix string) {
+	var sum float64
+	found := false
+	for k, v := range stat {
+		if !strings.HasPrefix(k, prefix) || !strings.HasSuffix(k, suffix) || len(k) <= len(prefix)+len(suffix) {
+			continue
+		}
+		if f, ok := toFloat(v); ok {
+			sum += f
+			found = true
+		}
+	}
+	if found {
+		stat[key] = sum
 	}
+}
+
+var invalidKeyChars = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
 
-	// Other lables are currently not supported
-	return base
+// sanitizeKey replaces characters which are not allowed in Mackerel metric names
+func sanitizeKey(s string) string {
+	return invalidKeyChars.ReplaceAllString(s, "_")
 }
 
 // GraphDefinition is an interface for mackerelplugin
 func (m MinioPlugin) GraphDefinition() map[string]mp.Graphs {
+	var graphs map[string]mp.Graphs
+	switch m.metricsVersion() {
+	case metricsVersionV2:
+		graphs = m.graphDefinitionV2()
+	case metricsVersionV3:
+		graphs = m.graphDefinitionV3()
+	case metricsVersionUnknown:
+		// nothing but the scrape and the health are graphed until the version is known
+		graphs = map[string]mp.Graphs{}
+	default:
+		graphs = m.graphDefinitionV1()
+	}
+
 	labelPrefix := strings.Title(m.Prefix)
-	return map[string]mp.Graphs{
+	for k, g := range m.graphDefinitionHealth(labelPrefix) {
+		graphs[k] = g
+	}
+	if m.ExpectedDrives > 0 {
+		graphs["drives_expected"] = mp.Graphs{
+			Label: (labelPrefix + " Expected Drives"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "drives_expected", Label: "Expected", Type: "uint64"},
+				{Name: "drives_missing", Label: "Missing"},
+			},
+		}
+	}
+	if m.AvailabilityObjective > 0 || m.latencyObjective() > 0 {
+		graphs["slo_burn_rate.#"] = mp.Graphs{
+			Label: (labelPrefix + " SLO Error Budget Burn Rate"),
+			Unit:  "float",
+			Metrics: []mp.Metrics{
+				{Name: "1h", Label: "1 Hour"},
+				{Name: "6h", Label: "6 Hours"},
+				{Name: "3d", Label: "3 Days"},
+			},
+		}
+		graphs["slo_budget_remaining"] = mp.Graphs{
+			Label: (labelPrefix + " SLO Error Budget Remaining"),
+			Unit:  "percentage",
+			Metrics: []mp.Metrics{
+				{Name: "slo_budget_remaining_availability", Label: "Availability"},
+				{Name: "slo_budget_remaining_latency", Label: "Latency"},
+			},
+		}
+	}
+	graphs["scrape.success"] = mp.Graphs{
+		Label: (labelPrefix + " Scrape Success"),
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "scrape_success", Label: "Success", Type: "uint64"},
+		},
+	}
+	graphs["scrape.breaker"] = mp.Graphs{
+		Label: (labelPrefix + " Scrape Circuit Breaker"),
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "breaker_open", Label: "Open", Type: "uint64"},
+			{Name: "breaker_consecutive_failures", Label: "Consecutive Failures", Type: "uint64"},
+		},
+	}
+	graphs["scrape.duration"] = mp.Graphs{
+		Label: (labelPrefix + " Scrape Duration"),
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "scrape_duration_seconds", Label: "Seconds"},
+		},
+	}
+
+	return graphs
+}
+
+func (m MinioPlugin) graphDefinitionV1() map[string]mp.Graphs {
+	labelPrefix := strings.Title(m.Prefix)
+	graphs := map[string]mp.Graphs{
 		"threads": {
 			Label: (labelPrefix + " Threads"),
 			Unit:  "integer",
@@ -169,6 +728,31 @@ func (m MinioPlugin) GraphDefinition() map[string]mp.Graphs {
 				{Name: "go_threads", Label: "OS Threads"},
 			},
 		},
+		"gc.pause": {
+			Label: (labelPrefix + " GC Pause Duration (seconds)"),
+			Unit:  "float",
+			Metrics: []mp.Metrics{
+				{Name: "go_gc_duration_seconds_quantile_0", Label: "Min"},
+				{Name: "go_gc_duration_seconds_quantile_0_25", Label: "25th Percentile"},
+				{Name: "go_gc_duration_seconds_quantile_0_5", Label: "Median"},
+				{Name: "go_gc_duration_seconds_quantile_0_75", Label: "75th Percentile"},
+				{Name: "go_gc_duration_seconds_quantile_1", Label: "Max"},
+			},
+		},
+		"gc.frequency": {
+			Label: (labelPrefix + " GC Frequency"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "go_gc_duration_seconds_count", Label: "GCs", Type: "uint64", Diff: true},
+			},
+		},
+		"gc.time": {
+			Label: (labelPrefix + " GC Pause Time (seconds)"),
+			Unit:  "float",
+			Metrics: []mp.Metrics{
+				{Name: "go_gc_duration_seconds_sum", Label: "Paused", Diff: true},
+			},
+		},
 		"memstats.alloc": {
 			Label: (labelPrefix + " Memory Stats"),
 			Unit:  "bytes",
@@ -226,60 +810,207 @@ func (m MinioPlugin) GraphDefinition() map[string]mp.Graphs {
 			Label: (labelPrefix + " HTTP Request Counts"),
 			Unit:  "integer",
 			Metrics: []mp.Metrics{
-				{Name: "promhttp_metric_handler_requests_total_200", Label: "200", Stacked: true, Type: "uint64"},
-				{Name: "promhttp_metric_handler_requests_total_500", Label: "500", Stacked: true, Type: "uint64"},
-				{Name: "promhttp_metric_handler_requests_total_503", Label: "503", Stacked: true, Type: "uint64"},
+				{Name: "*", Label: "%1", Stacked: true, Type: "uint64"},
 			},
 		},
-		"http_get": {
-			Label: (labelPrefix + " HTTP GET Request Duration"),
+		"http_duration.#": {
+			Label: (labelPrefix + " HTTP Request Duration"),
 			Unit:  "integer",
 			Metrics: []mp.Metrics{
-				{Name: "minio_http_requests_duration_seconds_GET_0_001", Label: "1ms", Type: "uint64", Diff: true},
-				{Name: "minio_http_requests_duration_seconds_GET_0_003", Label: "3ms", Type: "uint64", Diff: true},
-				{Name: "minio_http_requests_duration_seconds_GET_0_005", Label: "5ms", Type: "uint64", Diff: true},
-				{Name: "minio_http_requests_duration_seconds_GET_0_5", Label: "500ms", Type: "uint64", Diff: true},
-				{Name: "minio_http_requests_duration_seconds_GET_1", Label: "1s", Type: "uint64", Diff: true},
-				{Name: "minio_http_requests_duration_seconds_GET_total", Label: "total", Type: "uint64", Diff: true},
+				{Name: "*", Label: "%2", Type: "uint64", Diff: true},
 			},
 		},
-		"http_post": {
-			Label: (labelPrefix + " HTTP POST Request Duration"),
-			Unit:  "integer",
+		"http_latency.#": {
+			Label: (labelPrefix + " HTTP Request Latency (ms)"),
+			Unit:  "float",
 			Metrics: []mp.Metrics{
-				{Name: "minio_http_requests_duration_seconds_POST_0_001", Label: "1ms", Type: "uint64", Diff: true},
-				{Name: "minio_http_requests_duration_seconds_POST_0_003", Label: "3ms", Type: "uint64", Diff: true},
-				{Name: "minio_http_requests_duration_seconds_POST_0_005", Label: "5ms", Type: "uint64", Diff: true},
-				{Name: "minio_http_requests_duration_seconds_POST_0_5", Label: "500ms", Type: "uint64", Diff: true},
-				{Name: "minio_http_requests_duration_seconds_POST_1", Label: "1s", Type: "uint64", Diff: true},
-				{Name: "minio_http_requests_duration_seconds_POST_total", Label: "total", Type: "uint64", Diff: true},
+				{Name: "avg", Label: "Average"},
+				{Name: "p50", Label: "50th Percentile"},
+				{Name: "p90", Label: "90th Percentile"},
+				{Name: "p99", Label: "99th Percentile"},
 			},
 		},
-		"http_put": {
-			Label: (labelPrefix + " HTTP PUT Request Duration"),
-			Unit:  "integer",
+		"http_request_rate": {
+			Label: (labelPrefix + " HTTP Requests per Second"),
+			Unit:  "float",
 			Metrics: []mp.Metrics{
-				{Name: "minio_http_requests_duration_seconds_PUT_0_001", Label: "1ms", Type: "uint64", Diff: true},
-				{Name: "minio_http_requests_duration_seconds_PUT_0_003", Label: "3ms", Type: "uint64", Diff: true},
-				{Name: "minio_http_requests_duration_seconds_PUT_0_005", Label: "5ms", Type: "uint64", Diff: true},
-				{Name: "minio_http_requests_duration_seconds_PUT_0_5", Label: "500ms", Type: "uint64", Diff: true},
-				{Name: "minio_http_requests_duration_seconds_PUT_1", Label: "1s", Type: "uint64", Diff: true},
-				{Name: "minio_http_requests_duration_seconds_PUT_total", Label: "total", Type: "uint64", Diff: true},
+				{Name: "*", Label: "%1", Stacked: true},
 			},
 		},
-		"http_head": {
-			Label: (labelPrefix + " HTTP HEAD Request Duration"),
-			Unit:  "integer",
+	}
+
+	if len(m.LatencySLOs) > 0 {
+		graphs["http_apdex"] = mp.Graphs{
+			Label: (labelPrefix + " HTTP Apdex"),
+			Unit:  "float",
 			Metrics: []mp.Metrics{
-				{Name: "minio_http_requests_duration_seconds_HEAD_0_001", Label: "1ms", Type: "uint64", Diff: true},
-				{Name: "minio_http_requests_duration_seconds_HEAD_0_003", Label: "3ms", Type: "uint64", Diff: true},
-				{Name: "minio_http_requests_duration_seconds_HEAD_0_005", Label: "5ms", Type: "uint64", Diff: true},
-				{Name: "minio_http_requests_duration_seconds_HEAD_0_5", Label: "500ms", Type: "uint64", Diff: true},
-				{Name: "minio_http_requests_duration_seconds_HEAD_1", Label: "1s", Type: "uint64", Diff: true},
-				{Name: "minio_http_requests_duration_seconds_HEAD_total", Label: "total", Type: "uint64", Diff: true},
+				{Name: "*", Label: "%1"},
 			},
-		},
+		}
+		graphs["http_slo_compliance"] = mp.Graphs{
+			Label: (labelPrefix + " HTTP Requests within Latency Target"),
+			Unit:  "percentage",
+			Metrics: []mp.Metrics{
+				{Name: "*", Label: "%1"},
+			},
+		}
 	}
+	return graphs
+}
+
+// partialPlugin posts whatever FetchMetrics collected, since the helper discards
+// all of the metrics when an error is returned
+type partialPlugin struct {
+	mp.PluginWithPrefix
+}
+
+// nodePlugin is the plugin of a single node, keeping the metrics version probed by the scrape
+type nodePlugin struct {
+	node *MinioPlugin
+}
+
+// FetchMetrics interface for mackerelplugin
+func (p nodePlugin) FetchMetrics() (map[string]interface{}, error) {
+	return scrapeNode(p.node)
+}
+
+// GraphDefinition interface for mackerelplugin
+func (p nodePlugin) GraphDefinition() map[string]mp.Graphs {
+	return p.node.GraphDefinition()
+}
+
+// MetricKeyPrefix interface for PluginWithPrefix
+func (p nodePlugin) MetricKeyPrefix() string {
+	return p.node.MetricKeyPrefix()
+}
+
+// FetchMetrics is an interface for mackerelplugin
+func (p partialPlugin) FetchMetrics() (map[string]interface{}, error) {
+	stat, err := p.PluginWithPrefix.FetchMetrics()
+	if err != nil {
+		if len(stat) == 0 {
+			return nil, err
+		}
+		log.Printf("FetchMetrics (partial): %s", err)
+	}
+	return stat, nil
+}
+
+// selectNodes returns the nodes to scrape with the other settings of base, and the basename of their
+// Tempfile: the ones discovered by d, the endpoints, the servers, or the volumes of an environment file.
+// Only the flags given conflict with discovery, while the volumes are left for it. set has the names of
+// flags given explicitly.
+func selectNodes(endpoints, servers, volumes string, d discoverOptions, base MinioPlugin, set map[string]bool) ([]MinioPlugin, string, error) {
+	if d.kind != "" {
+		if endpoints != "" || servers != "" {
+			return nil, "", fmt.Errorf("-discover cannot be given with -endpoints or -servers")
+		}
+		return d.discover(base, set)
+	}
+	serversGiven := servers != ""
+	if !set["endpoints"] && !set["servers"] {
+		servers = volumes
+	}
+	return listNodes(endpoints, servers, serversGiven, base)
+}
+
+// listNodes returns the nodes of the endpoints or the servers, or base alone without them, and the basename
+// of their Tempfile. serversGiven tells -servers from the volumes of an environment file, whose single node
+// is the same as base.
+func listNodes(endpoints, servers string, serversGiven bool, base MinioPlugin) ([]MinioPlugin, string, error) {
+	switch {
+	case endpoints != "" && servers != "":
+		return nil, "", fmt.Errorf("-endpoints and -servers cannot be given together")
+	case endpoints != "":
+		nodes, err := ParseEndpoints(endpoints, base)
+		return nodes, fmt.Sprintf("%x", sha1.Sum([]byte(endpoints))), err
+	case servers != "":
+		nodes, err := ParseServers(servers, base)
+		if err != nil {
+			return nil, "", err
+		}
+		if serversGiven || len(nodes) > 1 {
+			return nodes, fmt.Sprintf("%x", sha1.Sum([]byte(servers))), nil
+		}
+		return nodes, fmt.Sprintf("%s-%s", base.Host, base.Port), nil
+	default:
+		return []MinioPlugin{base}, fmt.Sprintf("%s-%s", base.Host, base.Port), nil
+	}
+}
+
+// discoverOptions are the flags to discover the nodes with
+type discoverOptions struct {
+	// kind is proc, docker or kubernetes
+	kind         string
+	procfs       string
+	names        string
+	dockerSocket string
+	docker       DockerSelector
+	tenant       TenantDiscovery
+}
+
+// discover returns the nodes discovered with the other settings of base, and the basename of their
+// Tempfile. set has the names of flags given explicitly.
+func (d discoverOptions) discover(base MinioPlugin, set map[string]bool) ([]MinioPlugin, string, error) {
+	basename := "discover-" + d.kind
+	switch d.kind {
+	case "proc":
+		names, err := ParseNames(d.names)
+		if err != nil {
+			return nil, "", err
+		}
+		nodes, err := DiscoverProcesses(d.procfs, base, names)
+		return nodes, basename, err
+	case "docker":
+		ctx, cancel := base.context()
+		defer cancel()
+		nodes, err := DiscoverContainers(ctx, d.dockerSocket, base, d.docker)
+		return nodes, basename, err
+	case "kubernetes":
+		if d.tenant.Tenant == "" {
+			return nil, "", fmt.Errorf("-tenant is required to discover the tenant")
+		}
+		if !set["metric-key-prefix"] {
+			base.Prefix = d.tenant.Tenant
+		}
+		ctx, cancel := base.context()
+		defer cancel()
+		nodes, err := d.tenant.Discover(ctx, base)
+		if err != nil {
+			return nil, "", err
+		}
+		// The tenant decides the scheme unless it is given
+		if set["scheme"] {
+			for i := range nodes {
+				nodes[i].Scheme = base.Scheme
+			}
+		}
+		return nodes, basename + "-" + sanitizeKey(d.tenant.Namespace+"_"+d.tenant.Tenant), nil
+	default:
+		return nil, "", fmt.Errorf("unknown discovery: %s", d.kind)
+	}
+}
+
+// runCheck checks the nodes, prints the result and exits with its status. Checks scrape on every
+// run with their own state, not to skew the metrics plugin.
+func runCheck(multi MultiPlugin, multiple bool, basename string, thresholds CheckThresholds) {
+	for i := range multi.Nodes {
+		multi.Nodes[i].BreakerThreshold = 0
+	}
+	helper := mp.NewMackerelPlugin(multi)
+	helper.SetTempfileByBasename("mackerel-plugin-minio-check-" + basename)
+
+	var status CheckStatus
+	var msg string
+	if multiple {
+		multi.SetTempfile(helper.Tempfile)
+		status, msg = multi.Check(thresholds)
+	} else {
+		multi.Nodes[0].Tempfile = helper.Tempfile
+		status, msg = multi.Nodes[0].Check(thresholds)
+	}
+	fmt.Printf("MinIO %s: %s\n", status, msg)
+	os.Exit(int(status))
 }
 
 // Do the plugin
@@ -287,25 +1018,179 @@ func Do() {
 	optScheme := flag.String("scheme", "http", "Protocol scheme")
 	optHost := flag.String("host", "localhost", "Hostname")
 	optPort := flag.String("port", "9000", "Port")
-	optMetricsPath := flag.String("metrics-path", "/minio/prometheus/metrics", "Path to exported metrics")
+	optMetricsPath := flag.String("metrics-path", "/minio/prometheus/metrics", "Path to exported metrics (v1)")
+	optMetricsVersion := flag.String("metrics-version", metricsVersionAuto, "Metrics API version (v1, v2, v3 or auto)")
+	optMetricsGroups := flag.String("metrics-groups", strings.Join(defaultMetricsGroupsV3, ","), "Comma separated v3 metric groups to scrape")
+	optBearerToken := flag.String("bearer-token", "", "Bearer token for the metrics endpoints")
+	optBearerTokenFile := flag.String("bearer-token-file", "", "File containing the bearer token for the metrics endpoints")
+	optAccessKey := flag.String("access-key", "", "Access key to generate the bearer token (default: $MINIO_ACCESS_KEY or $MINIO_ROOT_USER)")
+	optSecretKey := flag.String("secret-key", "", "Secret key to generate the bearer token (default: $MINIO_SECRET_KEY or $MINIO_ROOT_PASSWORD)")
+	optCredentialsFile := flag.String("credentials-file", "", "JSON file containing accessKey and secretKey")
+	optCACert := flag.String("ca-cert", "", "CA certificate to verify the server")
+	optClientCert := flag.String("client-cert", "", "Client certificate for mutual TLS")
+	optClientKey := flag.String("client-key", "", "Client private key for mutual TLS")
+	optServerName := flag.String("server-name", "", "Server name to verify and send as SNI")
+	optInsecure := flag.Bool("insecure", false, "Skip verification of the server certificate")
+	optTimeout := flag.Duration("timeout", 15*time.Second, "Timeout of a whole scrape")
+	optRetries := flag.Int("retries", 2, "Number of retries on transient failures")
+	optRetryBackoff := flag.Duration("retry-backoff", 500*time.Millisecond, "Initial wait before a retry, doubled on every retry")
+	optBreakerThreshold := flag.Int("breaker-threshold", 5, "Consecutive failed runs to stop scraping for a while (0 disables)")
+	optBreakerCooldown := flag.Duration("breaker-cooldown", 5*time.Minute, "How long scraping is stopped once the breaker opens")
+	optLabelOrder := flag.String("label-order", strings.Join(defaultLabelOrder, ","), "Comma separated order of labels flattened into metric keys")
+	var optLabelRules labelRulesFlag
+	flag.Var(&optLabelRules, "label-rule", "Rule flattening labels of a family into metric keys, e.g. 'minio_node_drive_*:keep=drive;agg=max' (repeatable)")
+	optAvailabilityObjective := flag.Float64("availability-objective", 99.9, "Percentage of requests expected to succeed, tracked as an error budget (0 disables)")
+	optLatencyObjective := flag.Float64("latency-objective", 99, "Percentage of requests expected to be within their -latency-slo targets, tracked as an error budget (0 disables)")
+	var optLatencySLOs latencySLOsFlag
+	flag.Var(&optLatencySLOs, "latency-slo", "Latency target and tolerable threshold of a request type, e.g. 'GET:100ms,400ms' (repeatable)")
+	optHealthProbes := flag.String("health-probes", strings.Join(defaultHealthProbes, ","), "Comma separated health endpoints to probe (live, ready, cluster, cluster_read and maintenance)")
+	optEndpoints := flag.String("endpoints", "", "Comma separated endpoints of nodes to scrape at once instead of -host and -port, e.g. 'minio1:9000,minio2:9000'")
+	optServers := flag.String("servers", "", "Servers to scrape in the syntax of minio server arguments or MINIO_VOLUMES, e.g. 'http://minio{1...4}:9000/data{1...4}'")
+	optMinioEnvFile := flag.String("minio-env-file", "", "Environment file of the minio service to read the address, volumes, certs dir and credentials from, e.g. "+DefaultMinioEnvFile)
+	optMcAlias := flag.String("mc-alias", "", "Alias of the mc configuration to take the URL and keys from")
+	optMcConfig := flag.String("mc-config", "", "mc configuration file of -mc-alias (default: ~/.mc/config.json)")
+	optDiscover := flag.String("discover", "", "Discover the nodes to scrape instead of -host and -port (proc, docker or kubernetes)")
+	optProcfs := flag.String("procfs", DefaultProcfs, "Root of procfs to discover minio server processes in")
+	optDockerSocket := flag.String("docker-socket", DefaultDockerSocket, "Unix socket of Docker Engine API to discover MinIO containers through")
+	optDockerLabel := flag.String("docker-label", "", "Label of MinIO containers to discover, e.g. 'com.example.minio' or 'role=minio'")
+	optDockerImage := flag.String("docker-image", "", "Image of MinIO containers to discover (default: minio/minio without -docker-label)")
+	optKubeconfig := flag.String("kubeconfig", "", "kubeconfig file to discover the tenant with (default: the service account in a cluster, $KUBECONFIG or ~/.kube/config)")
+	optNamespace := flag.String("namespace", "", "Namespace of the tenant (default: the one of the context or the service account)")
+	optTenant := flag.String("tenant", "", "Tenant of MinIO Operator to discover")
+	optTenantSource := flag.String("tenant-source", tenantSourcePods, "Where the servers of the tenant are listed (pods or endpoints)")
+	optTenantSecret := flag.String("tenant-secret", "", "Secret holding the Prometheus bearer token or the root credentials (default: the configuration secret of the tenant)")
+	optDiscoverNames := flag.String("discover-names", "", "Comma separated names of discovered nodes by port instead of the ports, e.g. '9000:hot,9100:cold'")
+	optConcurrency := flag.Int("concurrency", defaultConcurrency, "Number of nodes scraped at once with -endpoints or -servers")
 	optPrefix := flag.String("metric-key-prefix", "minio", "Metric key prefix")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	optWarningOfflineDisks := flag.Float64("warning-offline-disks", 1, "Offline disks to warn of (check)")
+	optCriticalOfflineDisks := flag.Float64("critical-offline-disks", 2, "Offline disks to be critical (check)")
+	optWarningDiskUsage := flag.Float64("warning-disk-usage", 80, "Disk usage percentage to warn of (check)")
+	optCriticalDiskUsage := flag.Float64("critical-disk-usage", 90, "Disk usage percentage to be critical (check)")
+	optWarningFDUsage := flag.Float64("warning-fd-usage", 80, "File descriptor usage percentage to warn of (check)")
+	optCriticalFDUsage := flag.Float64("critical-fd-usage", 90, "File descriptor usage percentage to be critical (check)")
+	optWarningHealthLatency := flag.Float64("warning-health-latency", 0, "Health probe latency in milliseconds to warn of (check)")
+	optCriticalHealthLatency := flag.Float64("critical-health-latency", 0, "Health probe latency in milliseconds to be critical (check)")
+	optWarningScrapeFailures := flag.Float64("warning-scrape-failures", 1, "Consecutive failed scrapes to warn of (check)")
+	optCriticalScrapeFailures := flag.Float64("critical-scrape-failures", 3, "Consecutive failed scrapes to be critical (check)")
 
-	flag.Parse()
+	// "check" runs the binary as a check plugin instead of a metrics plugin
+	check := len(os.Args) > 1 && os.Args[1] == "check"
+	if check {
+		flag.CommandLine.Parse(os.Args[2:])
+	} else {
+		flag.Parse()
+	}
+	rand.Seed(time.Now().UnixNano())
+
+	fatal := func(err error) {
+		if check {
+			fmt.Printf("MinIO %s: %s\n", CheckUnknown, err)
+			os.Exit(int(CheckUnknown))
+		}
+		log.Fatal(err)
+	}
+
+	accessKey, secretKey, err := loadCredentials(*optAccessKey, *optSecretKey, *optCredentialsFile)
+	if err != nil {
+		fatal(err)
+	}
 
 	minio := MinioPlugin{
-		Scheme:      *optScheme,
-		Host:        *optHost,
-		Port:        *optPort,
-		MetricsPath: *optMetricsPath,
-		Prefix:      *optPrefix,
+		Scheme:                *optScheme,
+		Host:                  *optHost,
+		Port:                  *optPort,
+		MetricsPath:           *optMetricsPath,
+		MetricsGroups:         strings.Split(*optMetricsGroups, ","),
+		BearerToken:           *optBearerToken,
+		BearerTokenFile:       *optBearerTokenFile,
+		AccessKey:             accessKey,
+		SecretKey:             secretKey,
+		CACert:                *optCACert,
+		ClientCert:            *optClientCert,
+		ClientKey:             *optClientKey,
+		ServerName:            *optServerName,
+		Insecure:              *optInsecure,
+		Timeout:               *optTimeout,
+		Retries:               *optRetries,
+		RetryBackoff:          *optRetryBackoff,
+		BreakerThreshold:      *optBreakerThreshold,
+		BreakerCooldown:       *optBreakerCooldown,
+		LabelOrder:            strings.Split(*optLabelOrder, ","),
+		LabelRules:            optLabelRules,
+		LatencySLOs:           optLatencySLOs,
+		HealthProbes:          strings.Split(*optHealthProbes, ","),
+		AvailabilityObjective: *optAvailabilityObjective,
+		LatencyObjective:      *optLatencyObjective,
+		Prefix:                *optPrefix,
+	}
+	// Flags given explicitly take precedence over the environment file and the mc alias
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	var volumes string
+	if *optMinioEnvFile != "" {
+		env, err := LoadMinioEnv(*optMinioEnvFile)
+		if err != nil {
+			fatal(err)
+		}
+		env.Apply(&minio, set)
+		volumes = env.Volumes
+	}
+	if *optMcAlias != "" {
+		alias, err := LoadMcAlias(*optMcConfig, *optMcAlias)
+		if err != nil {
+			fatal(err)
+		}
+		alias.Apply(&minio, set)
+	}
+	switch *optMetricsVersion {
+	case metricsVersionV1, metricsVersionV2, metricsVersionV3, metricsVersionAuto:
+		minio.MetricsVersion = *optMetricsVersion
+	default:
+		fatal(fmt.Errorf("unknown metrics version: %s", *optMetricsVersion))
 	}
 
-	helper := mp.NewMackerelPlugin(minio)
+	d := discoverOptions{
+		kind:         *optDiscover,
+		procfs:       *optProcfs,
+		names:        *optDiscoverNames,
+		dockerSocket: *optDockerSocket,
+		docker:       DockerSelector{Label: *optDockerLabel, Image: *optDockerImage},
+		tenant:       TenantDiscovery{Kubeconfig: *optKubeconfig, Namespace: *optNamespace, Tenant: *optTenant, Source: *optTenantSource, Secret: *optTenantSecret},
+	}
+	nodes, basename, err := selectNodes(*optEndpoints, *optServers, volumes, d, minio, set)
+	if err != nil {
+		fatal(err)
+	}
+	// A single node of the environment file is scraped as without it, while discovered ones
+	// are always keyed by their names as they come and go
+	multiple := *optEndpoints != "" || *optServers != "" || *optDiscover != "" || len(nodes) > 1
+	// The prefix may be the one of the tenant discovered
+	multi := MultiPlugin{Nodes: nodes, Concurrency: *optConcurrency, Prefix: nodes[0].Prefix}
+
+	if check {
+		runCheck(multi, multiple, basename, CheckThresholds{
+			OfflineDisks:   Threshold{*optWarningOfflineDisks, *optCriticalOfflineDisks},
+			DiskUsage:      Threshold{*optWarningDiskUsage, *optCriticalDiskUsage},
+			FDUsage:        Threshold{*optWarningFDUsage, *optCriticalFDUsage},
+			HealthLatency:  Threshold{*optWarningHealthLatency, *optCriticalHealthLatency},
+			ScrapeFailures: Threshold{*optWarningScrapeFailures, *optCriticalScrapeFailures},
+		})
+	}
+
+	helper := mp.NewMackerelPlugin(partialPlugin{multi})
 	if *optTempfile != "" {
 		helper.Tempfile = *optTempfile
 	} else {
-		helper.SetTempfileByBasename(fmt.Sprintf("mackerel-plugin-minio-%s-%s", *optHost, *optPort))
+		helper.SetTempfileByBasename("mackerel-plugin-minio-" + basename)
+	}
+	// The plugin state is saved next to the helper's Tempfile
+	if multiple {
+		multi.SetTempfile(helper.Tempfile)
+		helper.Plugin = partialPlugin{multi}
+	} else {
+		nodes[0].Tempfile = helper.Tempfile
+		helper.Plugin = partialPlugin{nodePlugin{&nodes[0]}}
 	}
 
 	helper.Run()
@@ -1,12 +1,26 @@
 package mpminio
 
 import (
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/prometheus/prom2json"
 )
 
 func TestGraphDefinition(t *testing.T) {
-	want := 13
+	want := 18
 
 	s := SetupMockServer(t)
 	defer s.Server.Close()
@@ -22,6 +36,12 @@ func TestParseMetrics(t *testing.T) {
 		// threads
 		"go_goroutines": uint64(19),
 		"go_threads":    uint64(14),
+		// gc pause summary
+		"go_gc_duration_seconds_quantile_0":   float64(7.473e-06),
+		"go_gc_duration_seconds_quantile_0_5": float64(2.1819e-05),
+		"go_gc_duration_seconds_quantile_1":   float64(0.013692739),
+		"go_gc_duration_seconds_sum":          float64(0.563420551),
+		"go_gc_duration_seconds_count":        uint64(3395),
 		// memstats allocation
 		"go_memstats_alloc_bytes":       float64(5514112),
 		"go_memstats_alloc_bytes_total": float64(11288627704),
@@ -41,41 +61,43 @@ func TestParseMetrics(t *testing.T) {
 		// http inflight request counts
 		"promhttp_metric_handler_requests_in_flight": uint64(1),
 		// http request counts
-		"promhttp_metric_handler_requests_total_200": uint64(9256),
-		"promhttp_metric_handler_requests_total_500": uint64(0),
-		"promhttp_metric_handler_requests_total_503": uint64(0),
+		"http.request_counts.200": uint64(9256),
+		"http.request_counts.500": uint64(0),
+		"http.request_counts.503": uint64(0),
 		// http GET request duration
-		"minio_http_requests_duration_seconds_GET_0_001": uint64(9334),
-		"minio_http_requests_duration_seconds_GET_0_003": uint64(16760),
-		"minio_http_requests_duration_seconds_GET_0_005": uint64(17535),
-		"minio_http_requests_duration_seconds_GET_0_1":   uint64(18653),
-		"minio_http_requests_duration_seconds_GET_0_5":   uint64(18662),
-		"minio_http_requests_duration_seconds_GET_1":     uint64(18662),
-		"minio_http_requests_duration_seconds_GET_total": uint64(18666),
+		"http_duration.GET.0_001": uint64(9334),
+		"http_duration.GET.0_003": uint64(16760),
+		"http_duration.GET.0_005": uint64(17535),
+		"http_duration.GET.0_1":   uint64(18653),
+		"http_duration.GET.0_5":   uint64(18662),
+		"http_duration.GET.1":     uint64(18662),
+		"http_duration.GET.total": uint64(18666),
 		// http POST request duration
-		"minio_http_requests_duration_seconds_POST_0_001": uint64(11),
-		"minio_http_requests_duration_seconds_POST_0_003": uint64(13),
-		"minio_http_requests_duration_seconds_POST_0_005": uint64(13),
-		"minio_http_requests_duration_seconds_POST_0_1":   uint64(17),
-		"minio_http_requests_duration_seconds_POST_0_5":   uint64(22),
-		"minio_http_requests_duration_seconds_POST_1":     uint64(22),
-		"minio_http_requests_duration_seconds_POST_total": uint64(24),
+		"http_duration.POST.0_001": uint64(11),
+		"http_duration.POST.0_003": uint64(13),
+		"http_duration.POST.0_005": uint64(13),
+		"http_duration.POST.0_1":   uint64(17),
+		"http_duration.POST.0_5":   uint64(22),
+		"http_duration.POST.1":     uint64(22),
+		"http_duration.POST.total": uint64(24),
 		// http PUT request duration
-		"minio_http_requests_duration_seconds_PUT_0_001": uint64(0),
-		"minio_http_requests_duration_seconds_PUT_0_003": uint64(0),
-		"minio_http_requests_duration_seconds_PUT_0_005": uint64(71),
-		"minio_http_requests_duration_seconds_PUT_0_1":   uint64(135),
-		"minio_http_requests_duration_seconds_PUT_0_5":   uint64(135),
-		"minio_http_requests_duration_seconds_PUT_1":     uint64(144),
-		"minio_http_requests_duration_seconds_PUT_total": uint64(221),
+		"http_duration.PUT.0_001": uint64(0),
+		"http_duration.PUT.0_003": uint64(0),
+		"http_duration.PUT.0_005": uint64(71),
+		"http_duration.PUT.0_1":   uint64(135),
+		"http_duration.PUT.0_5":   uint64(135),
+		"http_duration.PUT.1":     uint64(144),
+		"http_duration.PUT.total": uint64(221),
 		// http HEAD request duration
-		"minio_http_requests_duration_seconds_HEAD_0_001": uint64(254),
-		"minio_http_requests_duration_seconds_HEAD_0_003": uint64(257),
-		"minio_http_requests_duration_seconds_HEAD_0_005": uint64(257),
-		"minio_http_requests_duration_seconds_HEAD_0_1":   uint64(257),
-		"minio_http_requests_duration_seconds_HEAD_0_5":   uint64(257),
-		"minio_http_requests_duration_seconds_HEAD_1":     uint64(257),
-		"minio_http_requests_duration_seconds_HEAD_total": uint64(257),
+		"http_duration.HEAD.0_001": uint64(254),
+		"http_duration.HEAD.0_003": uint64(257),
+		"http_duration.HEAD.0_005": uint64(257),
+		"http_duration.HEAD.0_1":   uint64(257),
+		"http_duration.HEAD.0_5":   uint64(257),
+		"http_duration.HEAD.1":     uint64(257),
+		"http_duration.HEAD.total": uint64(257),
+		// scrape
+		"scrape_success": uint64(1),
 	}
 
 	s := SetupMockServer(t)
@@ -91,3 +113,420 @@ func TestParseMetrics(t *testing.T) {
 		}
 	}
 }
+
+func TestParseMetricsV2(t *testing.T) {
+	wants := map[string]interface{}{
+		// node drives summed across drives, and each of them
+		"node.drives._data1.used_bytes": float64(60000000000),
+		"minio_node_drive_total_bytes":  float64(200000000000),
+		"minio_node_drive_used_bytes":   float64(100000000000),
+		"minio_node_drive_free_bytes":   float64(100000000000),
+		"minio_node_drive_used_percent": float64(50),
+		// node file descriptors (custom)
+		"minio_node_file_descriptor_used_percent": float64(25),
+		// s3 requests come from the node endpoint, not the cluster one
+		"s3.requests.getobject": float64(120),
+		"s3.requests.putobject": float64(30),
+		"s3.errors.getobject":   float64(2),
+		// cluster
+		"minio_cluster_capacity_usable_used_percent": float64(75),
+		"minio_cluster_drive_offline_total":          float64(1),
+		"minio_cluster_nodes_online_total":           float64(2),
+		// buckets
+		"bucket_size.images.total_bytes":    float64(1048576),
+		"bucket_size.logs_2019.total_bytes": float64(4096),
+		"bucket_objects.images.objects":     float64(42),
+		"bucket_objects.logs_2019.objects":  float64(7),
+	}
+
+	s := SetupMockServerV2(t)
+	defer s.Server.Close()
+
+	stat, err := s.plugin.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range wants {
+		if !reflect.DeepEqual(stat[k], v) {
+			t.Fatalf("%s: got=%v, want=%v\n", k, stat[k], v)
+		}
+	}
+}
+
+func TestDetectMetricsVersion(t *testing.T) {
+	tests := []struct {
+		setup func(*testing.T) *MockServer
+		want  string
+	}{
+		{SetupMockServer, metricsVersionV1},
+		{SetupMockServerV2, metricsVersionV2},
+	}
+
+	for _, tt := range tests {
+		s := tt.setup(t)
+		s.plugin.MetricsVersion = metricsVersionAuto
+		got := s.plugin.metricsVersion()
+		s.Server.Close()
+		if got != tt.want {
+			t.Fatalf("got=%s, want=%s", got, tt.want)
+		}
+	}
+}
+
+func TestUnknownMetricsVersion(t *testing.T) {
+	s := httptest.NewServer(http.NotFoundHandler())
+	defer s.Close()
+	u, _ := url.Parse(s.URL)
+	m := MinioPlugin{Scheme: "http", Host: u.Hostname(), Port: u.Port(), MetricsPath: "/minio/prometheus/metrics", MetricsVersion: metricsVersionAuto}
+
+	// neither v1 nor v2 is guessed for a node serving no metrics
+	if got := m.metricsVersion(); got != metricsVersionUnknown {
+		t.Fatalf("got=%s, want=%s", got, metricsVersionUnknown)
+	}
+	stat, err := m.FetchMetrics()
+	if err == nil || stat["scrape_success"] != uint64(0) {
+		t.Fatalf("got=%v (%v), want a failed scrape", stat, err)
+	}
+
+	m.MetricsVersion = metricsVersionUnknown
+	graphs := m.GraphDefinition()
+	if _, ok := graphs["scrape.success"]; !ok {
+		t.Fatal("graph scrape.success is not defined")
+	}
+	if _, ok := graphs["threads"]; ok {
+		t.Fatal("want no graphs of any metrics version")
+	}
+}
+
+func TestParseMetricsV3(t *testing.T) {
+	wants := map[string]interface{}{
+		// api/requests
+		"api_requests.count.GetObject":      float64(310),
+		"api_requests.count.PutObject":      float64(55),
+		"api_requests.errors.GetObject":     float64(3),
+		"minio_api_requests_inflight_total": float64(3),
+		// system/drive
+		"minio_system_drive_used_percent":       float64(20),
+		"system_drive.drives._data2.used_bytes": float64(10000000000),
+		"minio_system_drive_offline_count":      float64(0),
+		// cluster/health
+		"minio_cluster_health_capacity_usable_used_percent": float64(60),
+		"minio_cluster_health_nodes_online_count":           float64(4),
+		// bucket/replication
+		"bucket_replication_count.images.total_failed": float64(5),
+	}
+
+	s := SetupMockServerV3(t)
+	defer s.Server.Close()
+
+	stat, err := s.plugin.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range wants {
+		if !reflect.DeepEqual(stat[k], v) {
+			t.Fatalf("%s: got=%v, want=%v\n", k, stat[k], v)
+		}
+	}
+
+	graphdef := s.plugin.GraphDefinition()
+	for _, k := range []string{"api_requests.count", "system_drive.usage_percent", "cluster_health.nodes", "bucket_replication_count.#"} {
+		if _, ok := graphdef[k]; !ok {
+			t.Fatalf("graph %s is not defined", k)
+		}
+	}
+	if _, ok := graphdef["system_memory.usage"]; ok {
+		t.Fatal("graph of the group not scraped is defined")
+	}
+}
+
+func TestCalcMetricsMissingInputs(t *testing.T) {
+	stat := map[string]interface{}{
+		"process_open_fds":               uint64(8),
+		"minio_disk_storage_total_bytes": float64(100),
+		"minio_disk_storage_used_bytes":  float64(25),
+	}
+
+	err := calcMetrics(stat)
+	if err == nil || !strings.Contains(err.Error(), "process_max_fds") {
+		t.Fatalf("got=%v, want an error reporting process_max_fds", err)
+	}
+	if _, ok := stat["process_fds_percentage"]; ok {
+		t.Fatal("process_fds_percentage should be skipped")
+	}
+	if stat["minio_disk_storage_used_percent"] != float64(25) {
+		t.Fatalf("got=%v, want=25", stat["minio_disk_storage_used_percent"])
+	}
+}
+
+func TestFetchMetricsPartialFailure(t *testing.T) {
+	s := SetupMockServerV3(t)
+	defer s.Server.Close()
+	s.plugin.MetricsGroups = []string{"api/requests", "system/memory"}
+
+	stat, err := s.plugin.FetchMetrics()
+	errs, ok := err.(errorList)
+	if !ok || len(errs) != 1 || !isNotFound(errs[0]) {
+		t.Fatalf("got=%v, want a not found error of system/memory", err)
+	}
+	if stat["api_requests.count.GetObject"] != float64(310) {
+		t.Fatalf("got=%v, want=310", stat["api_requests.count.GetObject"])
+	}
+
+	stat, err = partialPlugin{s.plugin}.FetchMetrics()
+	if err != nil || len(stat) == 0 {
+		t.Fatalf("got=%v, want partial metrics without an error", err)
+	}
+}
+
+func TestFetchMetricsTimeout(t *testing.T) {
+	done := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+		}
+		fmt.Fprint(w, metrics)
+	}))
+	defer s.Close()
+	defer close(done)
+
+	u, _ := url.Parse(s.URL)
+	p := MinioPlugin{
+		Scheme:      "http",
+		Host:        u.Hostname(),
+		Port:        u.Port(),
+		MetricsPath: "/minio/prometheus/metrics",
+		Timeout:     50 * time.Millisecond,
+	}
+
+	stat, err := p.FetchMetrics()
+	if err == nil {
+		t.Fatal("got no error, want a timeout")
+	}
+	if stat["scrape_success"] != uint64(0) {
+		t.Fatalf("got=%v, want=0", stat["scrape_success"])
+	}
+	if d, _ := stat["scrape_duration_seconds"].(float64); d >= 1 {
+		t.Fatalf("scrape took %f seconds despite the timeout", d)
+	}
+}
+
+func TestFetchMetricsReusesConnections(t *testing.T) {
+	var conns int32
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, metrics)
+	}))
+	s.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	s.Start()
+	defer s.Close()
+
+	p := retryPlugin(t, s)
+	p.HealthProbes = []string{"live", "ready"}
+	if _, err := p.FetchMetrics(); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&conns); n != 1 {
+		t.Fatalf("got=%d connections, want the scrape and probes to share one", n)
+	}
+}
+
+func TestSumSeries(t *testing.T) {
+	stat := map[string]interface{}{
+		"node.drives._data1.used_bytes": float64(1),
+		"node.drives._data2.used_bytes": uint64(2),
+		"node.drives._data2.free_bytes": float64(4),
+	}
+
+	sumSeries(stat, "used", "node.drives.", ".used_bytes")
+	if stat["used"] != float64(3) {
+		t.Fatalf("got=%v, want=3", stat["used"])
+	}
+	sumSeries(stat, "total", "node.drives.", ".total_bytes")
+	if _, ok := stat["total"]; ok {
+		t.Fatal("total should be skipped without any drive")
+	}
+}
+
+func TestHandleSummary(t *testing.T) {
+	family := &prom2json.Family{
+		Name: "minio_custom_seconds",
+		Type: "SUMMARY",
+		Metrics: []interface{}{
+			prom2json.Summary{Labels: map[string]string{"server": "minio1"}, Quantiles: map[string]string{"0.5": "0.25", "0.99": "NaN"}, Sum: "1.5", Count: "3"},
+			prom2json.Summary{Labels: map[string]string{"server": "minio2"}, Quantiles: map[string]string{}, Sum: "0.5", Count: "1"},
+		},
+	}
+
+	stat := make(Stat)
+	if err := stat.handle(family, MinioPlugin{}.flattener(), nil); err != nil {
+		t.Fatal(err)
+	}
+	wants := map[string]interface{}{
+		"minio_custom_seconds_quantile_0_5": float64(0.25),
+		"minio_custom_seconds_sum":          float64(2),
+		"minio_custom_seconds_count":        uint64(4),
+	}
+	for k, v := range wants {
+		if !reflect.DeepEqual(stat[k], v) {
+			t.Fatalf("%s: got=%v, want=%v", k, stat[k], v)
+		}
+	}
+	if _, ok := stat["minio_custom_seconds_quantile_0_99"]; ok {
+		t.Fatal("NaN quantile should be skipped")
+	}
+}
+
+func TestHandleLabeledConversionError(t *testing.T) {
+	family := &prom2json.Family{
+		Name: "minio_node_process_uptime_seconds",
+		Type: "GAUGE",
+		Metrics: []interface{}{
+			prom2json.Metric{Labels: map[string]string{"server": "minio1"}, Value: "12"},
+			prom2json.Metric{Labels: map[string]string{"server": "minio2"}, Value: "broken"},
+		},
+	}
+
+	stat := make(Stat)
+	err := stat.handleLabeled(family, MinioPlugin{}.flattener())
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("got=%v, want the value failing to convert reported", err)
+	}
+	if stat["minio_node_process_uptime_seconds"] != float64(12) {
+		t.Fatalf("got=%v, want the other series kept", stat["minio_node_process_uptime_seconds"])
+	}
+}
+
+func TestHandleLabeledSummaryError(t *testing.T) {
+	family := &prom2json.Family{
+		Name: "minio_custom_seconds",
+		Type: "SUMMARY",
+		Metrics: []interface{}{
+			prom2json.Summary{Quantiles: map[string]string{"0.5": "broken"}, Sum: "1.5", Count: "3"},
+		},
+	}
+
+	stat := make(Stat)
+	if err := stat.handleLabeled(family, MinioPlugin{}.flattener()); err == nil {
+		t.Fatal("want the quantile failing to convert reported")
+	}
+	if stat["minio_custom_seconds_count"] != uint64(3) {
+		t.Fatalf("got=%v, want the count kept", stat["minio_custom_seconds_count"])
+	}
+}
+
+func TestListNodes(t *testing.T) {
+	base := MinioPlugin{Scheme: "http", Host: "localhost", Port: "9000"}
+	tests := []struct {
+		endpoints, servers string
+		serversGiven       bool
+		nodes              int
+		basename           string
+	}{
+		{"", "", false, 1, "localhost-9000"},
+		// a single node of an environment file is the same as base
+		{"", "/data{1...4}", false, 1, "localhost-9000"},
+		{"", "/data{1...4}", true, 1, fmt.Sprintf("%x", sha1.Sum([]byte("/data{1...4}")))},
+		{"minio1,minio2", "", false, 2, fmt.Sprintf("%x", sha1.Sum([]byte("minio1,minio2")))},
+	}
+	for _, tt := range tests {
+		nodes, basename, err := listNodes(tt.endpoints, tt.servers, tt.serversGiven, base)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(nodes) != tt.nodes || basename != tt.basename {
+			t.Fatalf("%+v: got=%d nodes %s", tt, len(nodes), basename)
+		}
+	}
+
+	if _, _, err := listNodes("minio1", "/data{1...4}", true, base); err == nil {
+		t.Fatal("want an error of -endpoints with -servers")
+	}
+}
+
+func TestDiscoverOptions(t *testing.T) {
+	for _, d := range []discoverOptions{{kind: "consul"}, {kind: "kubernetes"}} {
+		if _, _, err := d.discover(MinioPlugin{}, map[string]bool{}); err == nil {
+			t.Fatalf("%s: want an error", d.kind)
+		}
+	}
+}
+
+func TestNodePluginProbesWithinTimeout(t *testing.T) {
+	hung := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hung
+	}))
+	defer s.Close()
+	defer close(hung)
+	u, _ := url.Parse(s.URL)
+
+	timeout := 300 * time.Millisecond
+	node := MinioPlugin{Scheme: "http", Host: u.Hostname(), Port: u.Port(), MetricsVersion: metricsVersionAuto, Timeout: timeout}
+	p := nodePlugin{&node}
+	start := time.Now()
+	if _, err := p.FetchMetrics(); err == nil {
+		t.Fatal("want an error of the hung node")
+	}
+	// the probe shares the deadline of the scrape instead of adding its own
+	if elapsed := time.Since(start); elapsed >= 2*timeout {
+		t.Fatalf("took %s, want within %s", elapsed, timeout)
+	}
+	if node.MetricsVersion == metricsVersionAuto {
+		t.Fatal("want the version probed by the scrape kept for GraphDefinition")
+	}
+}
+
+func TestSelectNodesWithMinioEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mackerel-plugin-minio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "minio")
+	if err := ioutil.WriteFile(file, []byte("MINIO_VOLUMES=\"http://minio{1...2}:9000/data{1...2}\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	env, err := LoadMinioEnv(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := fakeProcfs(t,
+		map[string][]string{"100": {"minio", "server", "/mnt/data"}},
+		map[string][]string{"100": {"1001"}},
+		procNetHeader+"   0: 00000000:2328 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1001 1 0 100 0 0 10 0\n",
+		procNetHeader,
+	)
+	defer os.RemoveAll(root)
+
+	base := MinioPlugin{Scheme: "http", Host: "localhost", Port: "9000"}
+	set := map[string]bool{"minio-env-file": true, "discover": true}
+	d := discoverOptions{kind: "proc", procfs: root}
+
+	// the volumes of the environment file are left for the discovery
+	nodes, basename, err := selectNodes("", "", env.Volumes, d, base, set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 || nodeID(nodes[0]) != "9000" || basename != "discover-proc" {
+		t.Fatalf("got=%+v %s, want the process discovered", nodes, basename)
+	}
+
+	// without the discovery, the volumes are scraped
+	nodes, _, err = selectNodes("", "", env.Volumes, discoverOptions{}, base, map[string]bool{"minio-env-file": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 || nodes[0].ExpectedDrives != 2 {
+		t.Fatalf("got=%+v, want the servers of the volumes", nodes)
+	}
+
+	set["servers"] = true
+	if _, _, err := selectNodes("", env.Volumes, "", d, base, set); err == nil {
+		t.Fatal("want an error of -servers with -discover")
+	}
+}
@@ -188,28 +188,174 @@ promhttp_metric_handler_requests_total{code="500"} 0
 promhttp_metric_handler_requests_total{code="503"} 0
 `
 
+var metricsV2Node = `# HELP minio_node_drive_free_bytes Total storage available on a drive.
+# TYPE minio_node_drive_free_bytes gauge
+minio_node_drive_free_bytes{drive="/data1",server="minio1:9000"} 4.0e+10
+minio_node_drive_free_bytes{drive="/data2",server="minio1:9000"} 6.0e+10
+# HELP minio_node_drive_total_bytes Total storage on a drive.
+# TYPE minio_node_drive_total_bytes gauge
+minio_node_drive_total_bytes{drive="/data1",server="minio1:9000"} 1.0e+11
+minio_node_drive_total_bytes{drive="/data2",server="minio1:9000"} 1.0e+11
+# HELP minio_node_drive_used_bytes Total storage used on a drive.
+# TYPE minio_node_drive_used_bytes gauge
+minio_node_drive_used_bytes{drive="/data1",server="minio1:9000"} 6.0e+10
+minio_node_drive_used_bytes{drive="/data2",server="minio1:9000"} 4.0e+10
+# HELP minio_node_file_descriptor_limit_total Limit on total number of open file descriptors for the MinIO Server process.
+# TYPE minio_node_file_descriptor_limit_total gauge
+minio_node_file_descriptor_limit_total{server="minio1:9000"} 1024
+# HELP minio_node_file_descriptor_open_total Total number of open file descriptors by the MinIO Server process.
+# TYPE minio_node_file_descriptor_open_total gauge
+minio_node_file_descriptor_open_total{server="minio1:9000"} 256
+# HELP minio_node_go_routine_total Total number of go routines running.
+# TYPE minio_node_go_routine_total gauge
+minio_node_go_routine_total{server="minio1:9000"} 412
+# HELP minio_s3_requests_total Total number S3 requests
+# TYPE minio_s3_requests_total counter
+minio_s3_requests_total{api="getobject",server="minio1:9000"} 120
+minio_s3_requests_total{api="putobject",server="minio1:9000"} 30
+# HELP minio_s3_requests_errors_total Total number S3 requests with (4xx and 5xx) errors
+# TYPE minio_s3_requests_errors_total counter
+minio_s3_requests_errors_total{api="getobject",server="minio1:9000"} 2
+# HELP minio_s3_traffic_received_bytes Total number of s3 bytes received
+# TYPE minio_s3_traffic_received_bytes counter
+minio_s3_traffic_received_bytes{server="minio1:9000"} 1.048576e+06
+# HELP minio_s3_traffic_sent_bytes Total number of s3 bytes sent
+# TYPE minio_s3_traffic_sent_bytes counter
+minio_s3_traffic_sent_bytes{server="minio1:9000"} 2.097152e+06
+`
+
+var metricsV2Cluster = `# HELP minio_cluster_capacity_usable_free_bytes Total free usable capacity online in the cluster
+# TYPE minio_cluster_capacity_usable_free_bytes gauge
+minio_cluster_capacity_usable_free_bytes{server="minio1:9000"} 2.5e+11
+# HELP minio_cluster_capacity_usable_total_bytes Total usable capacity online in the cluster
+# TYPE minio_cluster_capacity_usable_total_bytes gauge
+minio_cluster_capacity_usable_total_bytes{server="minio1:9000"} 1.0e+12
+# HELP minio_cluster_drive_offline_total Total drives offline
+# TYPE minio_cluster_drive_offline_total gauge
+minio_cluster_drive_offline_total{server="minio1:9000"} 1
+# HELP minio_cluster_drive_online_total Total drives online
+# TYPE minio_cluster_drive_online_total gauge
+minio_cluster_drive_online_total{server="minio1:9000"} 7
+# HELP minio_cluster_nodes_offline_total Total number of MinIO nodes offline
+# TYPE minio_cluster_nodes_offline_total gauge
+minio_cluster_nodes_offline_total{server="minio1:9000"} 0
+# HELP minio_cluster_nodes_online_total Total number of MinIO nodes online
+# TYPE minio_cluster_nodes_online_total gauge
+minio_cluster_nodes_online_total{server="minio1:9000"} 2
+# HELP minio_s3_requests_total Total number S3 requests
+# TYPE minio_s3_requests_total counter
+minio_s3_requests_total{api="getobject",server="minio1:9000"} 120
+minio_s3_requests_total{api="getobject",server="minio2:9000"} 80
+minio_s3_requests_total{api="putobject",server="minio1:9000"} 30
+minio_s3_requests_total{api="putobject",server="minio2:9000"} 10
+`
+
+var metricsV2Bucket = `# HELP minio_bucket_usage_object_total Total number of objects
+# TYPE minio_bucket_usage_object_total gauge
+minio_bucket_usage_object_total{bucket="images",server="minio1:9000"} 42
+minio_bucket_usage_object_total{bucket="logs.2019",server="minio1:9000"} 7
+# HELP minio_bucket_usage_total_bytes Total bucket size in bytes
+# TYPE minio_bucket_usage_total_bytes gauge
+minio_bucket_usage_total_bytes{bucket="images",server="minio1:9000"} 1.048576e+06
+minio_bucket_usage_total_bytes{bucket="logs.2019",server="minio1:9000"} 4096
+`
+
+var metricsV3APIRequests = `# HELP minio_api_requests_total Total number of requests in the last minute
+# TYPE minio_api_requests_total counter
+minio_api_requests_total{name="GetObject",server="minio1:9000",type="s3"} 310
+minio_api_requests_total{name="PutObject",server="minio1:9000",type="s3"} 55
+# HELP minio_api_requests_errors_total Total number of requests with 4xx or 5xx errors in the last minute
+# TYPE minio_api_requests_errors_total counter
+minio_api_requests_errors_total{name="GetObject",server="minio1:9000",type="s3"} 3
+# HELP minio_api_requests_inflight_total Number of requests currently in flight
+# TYPE minio_api_requests_inflight_total gauge
+minio_api_requests_inflight_total{name="GetObject",server="minio1:9000",type="s3"} 2
+minio_api_requests_inflight_total{name="PutObject",server="minio1:9000",type="s3"} 1
+`
+
+var metricsV3SystemDrive = `# HELP minio_system_drive_total_bytes Total capacity of the drive in bytes
+# TYPE minio_system_drive_total_bytes gauge
+minio_system_drive_total_bytes{drive="/data1",server="minio1:9000"} 1.0e+11
+minio_system_drive_total_bytes{drive="/data2",server="minio1:9000"} 1.0e+11
+# HELP minio_system_drive_used_bytes Total storage used on the drive in bytes
+# TYPE minio_system_drive_used_bytes gauge
+minio_system_drive_used_bytes{drive="/data1",server="minio1:9000"} 3.0e+10
+minio_system_drive_used_bytes{drive="/data2",server="minio1:9000"} 1.0e+10
+# HELP minio_system_drive_offline_count Count of offline drives
+# TYPE minio_system_drive_offline_count gauge
+minio_system_drive_offline_count{server="minio1:9000"} 0
+`
+
+var metricsV3ClusterHealth = `# HELP minio_cluster_health_capacity_usable_free_bytes Total cluster usable storage free in bytes
+# TYPE minio_cluster_health_capacity_usable_free_bytes gauge
+minio_cluster_health_capacity_usable_free_bytes 4.0e+11
+# HELP minio_cluster_health_capacity_usable_total_bytes Total cluster usable storage capacity in bytes
+# TYPE minio_cluster_health_capacity_usable_total_bytes gauge
+minio_cluster_health_capacity_usable_total_bytes 1.0e+12
+# HELP minio_cluster_health_nodes_online_count Count of online nodes
+# TYPE minio_cluster_health_nodes_online_count gauge
+minio_cluster_health_nodes_online_count 4
+`
+
+var metricsV3BucketReplication = `# HELP minio_bucket_replication_total_failed_count Total number of objects which failed replication since server start
+# TYPE minio_bucket_replication_total_failed_count counter
+minio_bucket_replication_total_failed_count{bucket="images",server="minio1:9000"} 5
+`
+
 // MockServer represents a of mock metrics server for testing.
 type MockServer struct {
 	plugin MinioPlugin
 	t      *testing.T
+	// token is required as a bearer token when not empty
+	token  string
 	Server *httptest.Server
 }
 
 // SetupMockServer setups mock API server for testing.
 func SetupMockServer(t *testing.T) *MockServer {
+	return setupMockServer(t, "", map[string]string{
+		"/minio/prometheus/metrics": metrics,
+	})
+}
+
+// SetupMockServerV2 setups mock API server serving the v2 metrics endpoints for testing.
+func SetupMockServerV2(t *testing.T) *MockServer {
+	return setupMockServer(t, metricsVersionV2, map[string]string{
+		metricsPathV2Node:    metricsV2Node,
+		metricsPathV2Cluster: metricsV2Cluster,
+		metricsPathV2Bucket:  metricsV2Bucket,
+	})
+}
+
+// SetupMockServerV3 setups mock API server serving some of the v3 metric groups for testing.
+func SetupMockServerV3(t *testing.T) *MockServer {
+	m := setupMockServer(t, metricsVersionV3, map[string]string{
+		metricsPathV3 + "/api/requests":       metricsV3APIRequests,
+		metricsPathV3 + "/system/drive":       metricsV3SystemDrive,
+		metricsPathV3 + "/cluster/health":     metricsV3ClusterHealth,
+		metricsPathV3 + "/bucket/replication": metricsV3BucketReplication,
+	})
+	m.plugin.MetricsGroups = []string{"api/requests", "system/drive", "cluster/health", "/bucket/replication/"}
+	return m
+}
+
+func setupMockServer(t *testing.T, version string, routes map[string]string) *MockServer {
 	m := &MockServer{
 		plugin: MinioPlugin{
-			Scheme:      "http",
-			Host:        "localhost",
-			Port:        "9000",
-			MetricsPath: "/minio/prometheus/metrics",
-			Prefix:      "minio",
+			Scheme:         "http",
+			Host:           "localhost",
+			Port:           "9000",
+			MetricsPath:    "/minio/prometheus/metrics",
+			MetricsVersion: version,
+			Prefix:         "minio",
 		},
 		t: t,
 	}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc(m.plugin.MetricsPath, m.metricsHandler)
+	for path, body := range routes {
+		mux.HandleFunc(path, m.metricsHandler(body))
+	}
 
 	m.Server = httptest.NewUnstartedServer(mux)
 	// Close the listener created by NewUnstartedServer
@@ -223,8 +369,14 @@ func SetupMockServer(t *testing.T) *MockServer {
 	return m
 }
 
-func (m *MockServer) metricsHandler(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "text/plain")
-	fmt.Fprint(w, metrics)
+func (m *MockServer) metricsHandler(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if m.token != "" && r.Header.Get("Authorization") != "Bearer "+m.token {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, body)
+	}
 }
@@ -0,0 +1,222 @@
+package mpminio
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+)
+
+// defaultConcurrency bounds the nodes scraped at once
+const defaultConcurrency = 4
+
+// MultiPlugin scrapes several MinIO nodes at once. Metrics of each node are keyed as
+// <graph>.<metric>.<id>, and graphed with every node as a series. Cluster totals are graphed under cluster.
+type MultiPlugin struct {
+	// Nodes are scraped with their own Tempfile, set by SetTempfile, to keep their states apart
+	Nodes []MinioPlugin
+	// Concurrency is the number of nodes scraped at once. Zero means defaultConcurrency.
+	Concurrency int
+	Prefix      string
+}
+
+// MetricKeyPrefix interface for PluginWithPrefix
+func (m MultiPlugin) MetricKeyPrefix() string {
+	if m.Prefix == "" {
+		m.Prefix = "minio"
+	}
+	return m.Prefix
+}
+
+// nodeID returns the sanitized identifier of the node, e.g. minio1_9000, or its name
+func nodeID(node MinioPlugin) string {
+	if node.Name != "" {
+		return sanitizeKey(node.Name)
+	}
+	return sanitizeKey(node.Host + "_" + node.Port)
+}
+
+// SetTempfile gives each node a Tempfile derived from the one of the helper
+func (m MultiPlugin) SetTempfile(tempfile string) {
+	for i := range m.Nodes {
+		m.Nodes[i].Tempfile = tempfile + "." + nodeID(m.Nodes[i])
+	}
+}
+
+// forEachNode calls fn with the index of every node, running up to Concurrency at once
+func (m MultiPlugin) forEachNode(fn func(i int)) {
+	concurrency := m.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := range m.Nodes {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// detectMetricsVersions probes the nodes of auto mode concurrently, keeping their versions for later calls
+func (m MultiPlugin) detectMetricsVersions() {
+	m.forEachNode(func(i int) {
+		m.Nodes[i].MetricsVersion = m.Nodes[i].metricsVersion()
+	})
+}
+
+// FetchMetrics scrapes the nodes concurrently. A failing node is reported without discarding the others.
+// The metrics version of a node in auto mode is probed within its scrape, and kept for GraphDefinition.
+func (m MultiPlugin) FetchMetrics() (map[string]interface{}, error) {
+	stats := make([]map[string]interface{}, len(m.Nodes))
+	errs := make([]error, len(m.Nodes))
+	m.forEachNode(func(i int) {
+		stats[i], errs[i] = scrapeNode(&m.Nodes[i])
+	})
+
+	stat := map[string]interface{}{}
+	var errList errorList
+	for i, node := range m.Nodes {
+		id := nodeID(node)
+		if errs[i] != nil {
+			errList.add(fmt.Errorf("%s: %v", id, errs[i]))
+		}
+		graphKeys := plainGraphKeys(node.GraphDefinition())
+		for k, v := range stats[i] {
+			if g, ok := graphKeys[k]; ok {
+				k = g + "." + k
+			}
+			stat[k+"."+id] = v
+		}
+	}
+	for k, v := range calcClusterMetrics(stats) {
+		stat[k] = v
+	}
+	return stat, errList.err()
+}
+
+// Check checks the nodes concurrently, and returns the most severe status of them
+func (m MultiPlugin) Check(t CheckThresholds) (CheckStatus, string) {
+	statuses := make([]CheckStatus, len(m.Nodes))
+	msgs := make([]string, len(m.Nodes))
+	m.forEachNode(func(i int) {
+		statuses[i], msgs[i] = m.Nodes[i].Check(t)
+	})
+
+	status := CheckOK
+	for i, node := range m.Nodes {
+		if statuses[i] > status {
+			status = statuses[i]
+		}
+		msgs[i] = nodeID(node) + ": " + msgs[i]
+	}
+	return status, strings.Join(msgs, "; ")
+}
+
+// plainGraphKeys maps the metrics of graphs without wildcards to their graph keys. Across nodes,
+// they are keyed with their graph keys as wildcard graphs require, while the others are keyed as they are.
+func plainGraphKeys(graphs map[string]mp.Graphs) map[string]string {
+	keys := map[string]string{}
+	for k, g := range graphs {
+		for _, metric := range g.Metrics {
+			if !strings.ContainsAny(k+metric.Name, "*#") {
+				keys[metric.Name] = k
+			}
+		}
+	}
+	return keys
+}
+
+// GraphDefinition interface for mackerelplugin. The graphs of every node are merged, since nodes may
+// serve different metrics versions, along with cluster graphs. Nodes not scraped yet are probed first.
+func (m MultiPlugin) GraphDefinition() map[string]mp.Graphs {
+	m.detectMetricsVersions()
+	graphs := map[string]mp.Graphs{}
+	for _, node := range m.Nodes {
+		for k, g := range nodeGraphs(node.GraphDefinition()) {
+			graphs[k] = g
+		}
+	}
+	for k, g := range graphDefinitionCluster(strings.Title(m.MetricKeyPrefix())) {
+		graphs[k] = g
+	}
+	return graphs
+}
+
+// nodeGraphs turns each metric of the graphs of a node into a graph of its own, <graph>.<metric>, with a
+// series for every node. Wildcards of the metric become the ones of the graph. Since node IDs have no dots,
+// no metric name prefixing another one in the same graph is matched twice.
+func nodeGraphs(graphs map[string]mp.Graphs) map[string]mp.Graphs {
+	nodeGraphs := map[string]mp.Graphs{}
+	for k, g := range graphs {
+		for _, metric := range g.Metrics {
+			key := k + "." + strings.Replace(metric.Name, "*", "#", -1)
+			label := g.Label
+			if metric.Label != "" && !strings.Contains(metric.Label, "%") {
+				label += ": " + metric.Label
+			}
+			nodeGraphs[key] = mp.Graphs{
+				Label: label,
+				Unit:  g.Unit,
+				Metrics: []mp.Metrics{{
+					Name:    "*",
+					Label:   "%" + strconv.Itoa(strings.Count(key, "#")+1),
+					Diff:    metric.Diff,
+					Type:    metric.Type,
+					Stacked: metric.Stacked,
+					Scale:   metric.Scale,
+				}},
+			}
+		}
+	}
+	return nodeGraphs
+}
+
+// ParseEndpoints parses comma separated endpoints such as "minio1:9000" or "https://minio2:9000"
+// into nodes sharing the other settings of base
+func ParseEndpoints(s string, base MinioPlugin) ([]MinioPlugin, error) {
+	nodes := []MinioPlugin{}
+	seen := map[string]bool{}
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e == "" {
+			continue
+		}
+		if !strings.Contains(e, "://") {
+			e = base.Scheme + "://" + e
+		}
+		u, err := url.Parse(e)
+		if err != nil || u.Hostname() == "" {
+			return nil, fmt.Errorf("invalid endpoint %q", e)
+		}
+
+		node := base
+		node.Scheme = u.Scheme
+		node.Host = u.Hostname()
+		if p := u.Port(); p != "" {
+			node.Port = p
+		}
+		id := nodeID(node)
+		if seen[id] {
+			return nil, fmt.Errorf("endpoint %q is given twice", e)
+		}
+		seen[id] = true
+		nodes = append(nodes, node)
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no endpoint is given in %q", s)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodeID(nodes[i]) < nodeID(nodes[j]) })
+	return nodes, nil
+}
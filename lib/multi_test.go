@@ -0,0 +1,171 @@
+package mpminio
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+)
+
+func TestMultiPluginFetchMetrics(t *testing.T) {
+	s := SetupMockServer(t)
+	defer s.Server.Close()
+
+	// a node which is down
+	down := httptest.NewServer(http.NotFoundHandler())
+	u, _ := url.Parse(down.URL)
+	down.Close()
+
+	nodes, err := ParseEndpoints("localhost:9000,"+u.Host, s.plugin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	multi := MultiPlugin{Nodes: nodes, Concurrency: 1}
+	downID := sanitizeKey(u.Hostname() + "_" + u.Port())
+
+	stat, err := multi.FetchMetrics()
+	if err == nil || !strings.Contains(err.Error(), downID) {
+		t.Fatalf("got=%v, want an error of %s", err, downID)
+	}
+	wants := map[string]interface{}{
+		// plain graphs are keyed with their graph keys
+		"threads.go_goroutines.localhost_9000":         uint64(19),
+		"scrape.success.scrape_success.localhost_9000": uint64(1),
+		// wildcard graphs are keyed as they are
+		"http_duration.GET.0_001.localhost_9000":  uint64(9334),
+		"scrape.success.scrape_success." + downID: uint64(0),
+		// cluster
+		"cluster_nodes_up":    uint64(1),
+		"cluster_nodes_total": uint64(2),
+	}
+	for k, v := range wants {
+		if !reflect.DeepEqual(stat[k], v) {
+			t.Fatalf("%s: got=%v, want=%v", k, stat[k], v)
+		}
+	}
+
+	graphs := multi.GraphDefinition()
+	for _, k := range []string{"threads.go_goroutines", "http_duration.#.#", "scrape.success.scrape_success", "cluster.nodes"} {
+		if _, ok := graphs[k]; !ok {
+			t.Fatalf("graph %s is not defined", k)
+		}
+	}
+	if label := graphs["http_duration.#.#"].Metrics[0].Label; label != "%3" {
+		t.Fatalf("got=%s, want the node labeled by the last wildcard", label)
+	}
+
+	// no metric of the nodes is posted twice, even when its name prefixes another one of the same graph
+	for k := range stat {
+		if n := countMatches(graphs, k); n > 1 {
+			t.Fatalf("%s: matched by %d graph metrics, want 1", k, n)
+		}
+	}
+	for _, k := range []string{"gc.pause.go_gc_duration_seconds_quantile_0.localhost_9000", "memstats.alloc.go_memstats_alloc_bytes.localhost_9000"} {
+		if _, ok := stat[k]; !ok {
+			t.Fatalf("%s is not fetched", k)
+		}
+		if n := countMatches(graphs, k); n != 1 {
+			t.Fatalf("%s: matched by %d graph metrics, want 1", k, n)
+		}
+	}
+}
+
+// countMatches counts the metrics of the graphs matching the key, in the way
+// go-mackerel-plugin-helper matches wildcard metrics
+func countMatches(graphs map[string]mp.Graphs, key string) int {
+	n := 0
+	for k, g := range graphs {
+		for _, metric := range g.Metrics {
+			name := k + "." + metric.Name
+			if !strings.ContainsAny(name, "*#") {
+				if metric.Name == key {
+					n++
+				}
+				continue
+			}
+			pattern := regexp.QuoteMeta(name)
+			pattern = strings.Replace(pattern, `\*`, "[-a-zA-Z0-9_]+", -1)
+			pattern = strings.Replace(pattern, "#", "[-a-zA-Z0-9_]+", -1)
+			if regexp.MustCompile(`\A` + pattern).MatchString(key) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func TestMultiPluginDetectMetricsVersions(t *testing.T) {
+	s := SetupMockServerV2(t)
+	defer s.Server.Close()
+
+	s.plugin.MetricsVersion = metricsVersionAuto
+	multi := MultiPlugin{Nodes: []MinioPlugin{s.plugin}}
+	if _, err := multi.FetchMetrics(); err != nil {
+		t.Fatal(err)
+	}
+	// probed by the scrape and kept for GraphDefinition
+	if got := multi.Nodes[0].MetricsVersion; got != metricsVersionV2 {
+		t.Fatalf("got=%s, want=%s", got, metricsVersionV2)
+	}
+
+	multi.Nodes[0].MetricsVersion = metricsVersionAuto
+	if _, ok := multi.GraphDefinition()["s3.requests.#"]; !ok {
+		t.Fatal("want the v2 graphs of the node probed")
+	}
+	if got := multi.Nodes[0].MetricsVersion; got != metricsVersionV2 {
+		t.Fatalf("got=%s, want=%s", got, metricsVersionV2)
+	}
+}
+
+func TestMultiPluginProbesWithinTimeout(t *testing.T) {
+	hung := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hung
+	}))
+	defer s.Close()
+	defer close(hung)
+	u, _ := url.Parse(s.URL)
+
+	timeout := 300 * time.Millisecond
+	node := MinioPlugin{Scheme: "http", Host: u.Hostname(), Port: u.Port(), MetricsVersion: metricsVersionAuto, Timeout: timeout}
+	multi := MultiPlugin{Nodes: []MinioPlugin{node}}
+	start := time.Now()
+	multi.FetchMetrics()
+	// the same as a single node, the probe shares the deadline of the scrape
+	if elapsed := time.Since(start); elapsed >= 2*timeout {
+		t.Fatalf("took %s, want within %s", elapsed, timeout)
+	}
+	if got := multi.Nodes[0].MetricsVersion; got != metricsVersionUnknown {
+		t.Fatalf("got=%s, want=%s", got, metricsVersionUnknown)
+	}
+}
+
+func TestParseEndpoints(t *testing.T) {
+	base := MinioPlugin{Scheme: "http", Port: "9000", Prefix: "minio"}
+
+	nodes, err := ParseEndpoints("minio2, https://minio1:9443", base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, n := range nodes {
+		u := n.endpoint("")
+		got = append(got, u.String())
+	}
+	want := []string{"https://minio1:9443", "http://minio2:9000"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got=%v, want=%v", got, want)
+	}
+
+	for _, s := range []string{"", "minio1,minio1:9000", "http://:9000"} {
+		if _, err := ParseEndpoints(s, base); err == nil {
+			t.Fatalf("%q: want an error", s)
+		}
+	}
+}
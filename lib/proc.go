@@ -0,0 +1,282 @@
+package mpminio
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultProcfs is the root of procfs to discover processes in
+const DefaultProcfs = "/proc"
+
+// tcpListen is the state of listening sockets in /proc/net/tcp
+const tcpListen = "0A"
+
+// minioProcess is a minio server process found in procfs
+type minioProcess struct {
+	pid            int
+	address        string
+	consoleAddress string
+	certsDir       string
+	// listens are the addresses the process listens on
+	listens []string
+}
+
+// DiscoverProcesses finds the minio server processes in procfs, rooted at procfs to be faked in tests,
+// and returns a node for each with the other settings of base. Nodes are named by their ports, or by
+// the names of the ports given.
+func DiscoverProcesses(procfs string, base MinioPlugin, names map[string]string) ([]MinioPlugin, error) {
+	procs, err := findMinioProcesses(procfs)
+	if err != nil {
+		return nil, err
+	}
+	if len(procs) == 0 {
+		return nil, fmt.Errorf("no minio server process is found in %s", procfs)
+	}
+	listens, err := listeningSockets(procfs)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []MinioPlugin{}
+	seen := map[string]bool{}
+	for _, p := range procs {
+		for _, inode := range socketInodes(procfs, p.pid) {
+			if a, ok := listens[inode]; ok {
+				p.listens = append(p.listens, a)
+			}
+		}
+		host, port, ok := p.endpoint()
+		if !ok {
+			continue
+		}
+
+		node := base
+		node.Host = host
+		node.Port = port
+		node.Name = port
+		if name, ok := names[port]; ok {
+			node.Name = name
+		}
+		if p.certsDir != "" {
+			// the certificates of the process turn on TLS and are trusted unless others are given
+			MinioEnv{CertsDir: p.certsDir}.Apply(&node, map[string]bool{"ca-cert": base.CACert != "", "insecure": base.Insecure})
+		}
+		if id := nodeID(node); !seen[id] {
+			seen[id] = true
+			nodes = append(nodes, node)
+		}
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no listening address of minio server processes is found in %s", procfs)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodeID(nodes[i]) < nodeID(nodes[j]) })
+	return nodes, nil
+}
+
+// ParseNames parses comma separated names of ports such as "9000:hot,9100:cold"
+func ParseNames(s string) (map[string]string, error) {
+	names := map[string]string{}
+	for _, n := range strings.Split(s, ",") {
+		if n = strings.TrimSpace(n); n == "" {
+			continue
+		}
+		i := strings.Index(n, ":")
+		if i <= 0 || i == len(n)-1 {
+			return nil, fmt.Errorf("invalid name %q, want <port>:<name>", n)
+		}
+		names[n[:i]] = n[i+1:]
+	}
+	return names, nil
+}
+
+// findMinioProcesses reads the command lines of the processes, and returns the ones running minio server
+func findMinioProcesses(procfs string) ([]minioProcess, error) {
+	dirs, err := ioutil.ReadDir(procfs)
+	if err != nil {
+		return nil, fmt.Errorf("reading procfs failed: %v", err)
+	}
+
+	procs := []minioProcess{}
+	for _, d := range dirs {
+		pid, err := strconv.Atoi(d.Name())
+		if err != nil {
+			continue
+		}
+		// processes may exit or be hidden meanwhile
+		b, err := ioutil.ReadFile(filepath.Join(procfs, d.Name(), "cmdline"))
+		if err != nil {
+			continue
+		}
+		if p, ok := parseMinioCmdline(strings.Split(strings.TrimRight(string(b), "\x00"), "\x00")); ok {
+			p.pid = pid
+			procs = append(procs, p)
+		}
+	}
+	return procs, nil
+}
+
+// parseMinioCmdline parses the arguments of minio server
+func parseMinioCmdline(args []string) (minioProcess, bool) {
+	if len(args) < 2 || filepath.Base(args[0]) != "minio" {
+		return minioProcess{}, false
+	}
+	p := minioProcess{}
+	server := false
+	for i := 1; i < len(args); i++ {
+		name, value, inline := args[i], "", false
+		if j := strings.Index(name, "="); j > 0 {
+			name, value, inline = name[:j], name[j+1:], true
+		}
+		switch name {
+		case "server":
+			server = true
+			continue
+		case "--address", "--console-address", "--certs-dir", "-S":
+		default:
+			continue
+		}
+		if !inline && i+1 < len(args) {
+			i++
+			value = args[i]
+		}
+		switch name {
+		case "--address":
+			p.address = value
+		case "--console-address":
+			p.consoleAddress = value
+		default:
+			p.certsDir = value
+		}
+	}
+	return p, server
+}
+
+// endpoint returns the host and port the API of the process is served on. The address given
+// takes precedence, then the default port, and then the lowest port which is not the console.
+func (p minioProcess) endpoint() (string, string, bool) {
+	_, consolePort, _ := net.SplitHostPort(p.consoleAddress)
+	listens := map[string]string{}
+	ports := []int{}
+	for _, a := range p.listens {
+		host, port, err := net.SplitHostPort(a)
+		if err != nil || port == consolePort {
+			continue
+		}
+		if _, ok := listens[port]; !ok {
+			n, _ := strconv.Atoi(port)
+			ports = append(ports, n)
+		}
+		listens[port] = host
+	}
+	sort.Ints(ports)
+
+	var host, port string
+	if p.address != "" {
+		h, pt, err := net.SplitHostPort(p.address)
+		if err != nil {
+			return "", "", false
+		}
+		host, port = h, pt
+		if host == "" {
+			host = listens[port]
+		}
+	} else if h, ok := listens["9000"]; ok {
+		host, port = h, "9000"
+	} else if len(ports) > 0 {
+		port = strconv.Itoa(ports[0])
+		host = listens[port]
+	} else {
+		return "", "", false
+	}
+
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "localhost"
+	}
+	return host, port, true
+}
+
+// socketInodes returns the inodes of the sockets the process has open
+func socketInodes(procfs string, pid int) []string {
+	fdDir := filepath.Join(procfs, strconv.Itoa(pid), "fd")
+	fds, err := ioutil.ReadDir(fdDir)
+	if err != nil {
+		return nil
+	}
+	inodes := []string{}
+	for _, fd := range fds {
+		link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+		if err != nil {
+			continue
+		}
+		if strings.HasPrefix(link, "socket:[") && strings.HasSuffix(link, "]") {
+			inodes = append(inodes, link[len("socket:["):len(link)-1])
+		}
+	}
+	return inodes
+}
+
+// listeningSockets maps the inodes of listening TCP sockets to their addresses, from net/tcp and net/tcp6
+func listeningSockets(procfs string) (map[string]string, error) {
+	sockets := map[string]string{}
+	found := false
+	for _, name := range []string{"tcp", "tcp6"} {
+		f, err := os.Open(filepath.Join(procfs, "net", name))
+		if err != nil {
+			continue
+		}
+		found = true
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			// the header is skipped as well
+			if len(fields) < 10 || fields[3] != tcpListen {
+				continue
+			}
+			if a, ok := parseProcAddress(fields[1]); ok {
+				sockets[fields[9]] = a
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading %s failed: %v", name, err)
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("neither net/tcp nor net/tcp6 is found in %s", procfs)
+	}
+	return sockets, nil
+}
+
+// parseProcAddress parses an address of /proc/net/tcp, e.g. 0100007F:2328 into 127.0.0.1:9000.
+// IP addresses are hexadecimal words in host byte order, which is little endian on common platforms.
+func parseProcAddress(s string) (string, bool) {
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return "", false
+	}
+	b, err := hex.DecodeString(s[:i])
+	if err != nil || len(b) != net.IPv4len && len(b) != net.IPv6len {
+		return "", false
+	}
+	port, err := strconv.ParseUint(s[i+1:], 16, 16)
+	if err != nil {
+		return "", false
+	}
+
+	ip := make(net.IP, len(b))
+	for w := 0; w < len(b); w += 4 {
+		for j := 0; j < 4; j++ {
+			ip[w+j] = b[w+3-j]
+		}
+	}
+	return net.JoinHostPort(ip.String(), strconv.FormatUint(port, 10)), true
+}
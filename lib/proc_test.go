@@ -0,0 +1,126 @@
+package mpminio
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeProcfs builds a procfs tree of processes with their command lines and socket inodes
+func fakeProcfs(t *testing.T, procs map[string][]string, sockets map[string][]string, tcp, tcp6 string) string {
+	root, err := ioutil.TempDir("", "mackerel-plugin-minio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for pid, args := range procs {
+		fdDir := filepath.Join(root, pid, "fd")
+		if err := os.MkdirAll(fdDir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(root, pid, "cmdline"), []byte(strings.Join(args, "\x00")+"\x00"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		for i, inode := range sockets[pid] {
+			if err := os.Symlink("socket:["+inode+"]", filepath.Join(fdDir, string(rune('3'+i)))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(root, "net"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "net", "tcp"), []byte(tcp), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "net", "tcp6"), []byte(tcp6), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+const procNetHeader = "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+
+func TestDiscoverProcesses(t *testing.T) {
+	root := fakeProcfs(t,
+		map[string][]string{
+			"100": {"minio", "server", "--address", ":9100", "--console-address=:9101", "/mnt/hot"},
+			"200": {"/usr/local/bin/minio", "server", "/mnt/cold"},
+			"300": {"/bin/bash"},
+			"400": {"minio", "gateway", "nas", "/mnt/nas"},
+		},
+		map[string][]string{
+			"100": {"1001", "1002"},
+			"200": {"2001", "2002", "2003"},
+			"300": {"3001"},
+		},
+		procNetHeader+
+			"   0: 00000000:238C 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1001 1 0 100 0 0 10 0\n"+
+			"   1: 0100007F:238D 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1002 1 0 100 0 0 10 0\n"+
+			"   2: 0100007F:2328 0100007F:D431 01 00000000:00000000 00:00000000 00000000     0        0 2003 1 0 100 0 0 10 0\n"+
+			"   3: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 3001 1 0 100 0 0 10 0\n",
+		procNetHeader+
+			"   0: 00000000000000000000000000000000:2328 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 2001 1 0 100 0 0 10 0\n"+
+			"   1: 00000000000000000000000001000000:9088 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 2002 1 0 100 0 0 10 0\n",
+	)
+	defer os.RemoveAll(root)
+
+	base := MinioPlugin{Scheme: "http", Host: "ignored", Port: "1", Prefix: "minio"}
+	nodes, err := DiscoverProcesses(root, base, map[string]string{"9000": "cold"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, n := range nodes {
+		u := n.endpoint("")
+		got[nodeID(n)] = u.String()
+	}
+	want := map[string]string{
+		"9100": "http://localhost:9100",
+		"cold": "http://localhost:9000",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got=%v, want=%v", got, want)
+	}
+
+	if _, err := DiscoverProcesses(filepath.Join(root, "300"), base, nil); err == nil {
+		t.Fatal("want an error without minio server processes")
+	}
+}
+
+func TestMinioProcessEndpoint(t *testing.T) {
+	tests := []struct {
+		p          minioProcess
+		host, port string
+		ok         bool
+	}{
+		{minioProcess{address: "10.0.0.1:9100"}, "10.0.0.1", "9100", true},
+		{minioProcess{listens: []string{"[::1]:9000", "[::1]:39000"}}, "::1", "9000", true},
+		// the lowest port except the console
+		{minioProcess{consoleAddress: ":9001", listens: []string{"0.0.0.0:9001", "0.0.0.0:9200", "0.0.0.0:9300"}}, "localhost", "9200", true},
+		{minioProcess{}, "", "", false},
+	}
+	for _, tt := range tests {
+		host, port, ok := tt.p.endpoint()
+		if host != tt.host || port != tt.port || ok != tt.ok {
+			t.Fatalf("%+v: got=%s %s %v, want=%s %s %v", tt.p, host, port, ok, tt.host, tt.port, tt.ok)
+		}
+	}
+}
+
+func TestParseNames(t *testing.T) {
+	names, err := ParseNames("9000:hot, 9100:cold")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"9000": "hot", "9100": "cold"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("got=%v, want=%v", names, want)
+	}
+	for _, s := range []string{"9000", ":hot", "9000:"} {
+		if _, err := ParseNames(s); err == nil {
+			t.Fatalf("%q: want an error", s)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package mpminio
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// retryable reports whether the failed request is worth another attempt.
+// Client errors such as 404 or 401 will fail in the same way again.
+func retryable(err error) bool {
+	if se, ok := err.(*statusError); ok {
+		return se.StatusCode >= 500
+	}
+	return true
+}
+
+// backoff returns the wait before the given retry, doubling RetryBackoff with equal jitter
+func (m MinioPlugin) backoff(retry int) time.Duration {
+	d := m.RetryBackoff << uint(retry)
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// wait sleeps for d unless it does not fit in the time budget of ctx
+func wait(ctx context.Context, d time.Duration) bool {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+		return false
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// breakerState counts consecutive scrapes collecting nothing to stop hammering a sick node
+type breakerState struct {
+	ConsecutiveFailures int   `json:"consecutive_failures"`
+	OpenUntil           int64 `json:"open_until,omitempty"`
+}
+
+// isOpen reports whether scrapes should be skipped at now
+func (b breakerState) isOpen(now time.Time) bool {
+	return b.OpenUntil > now.Unix()
+}
+
+// record updates the breaker with the result of a scrape. Once the threshold is reached,
+// it opens for cooldown, and a single failure after that reopens it until a scrape succeeds.
+func (b *breakerState) record(success bool, threshold int, cooldown time.Duration, now time.Time) {
+	if success {
+		*b = breakerState{}
+		return
+	}
+
+	b.ConsecutiveFailures++
+	if threshold > 0 && b.ConsecutiveFailures >= threshold {
+		b.OpenUntil = now.Add(cooldown).Unix()
+	}
+}
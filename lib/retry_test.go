@@ -0,0 +1,146 @@
+package mpminio
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyServer fails the first failures requests with 503 and counts every request
+func flakyServer(failures int32, requests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(requests, 1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, metrics)
+	}))
+}
+
+func retryPlugin(t *testing.T, s *httptest.Server) MinioPlugin {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return MinioPlugin{
+		Scheme:       "http",
+		Host:         u.Hostname(),
+		Port:         u.Port(),
+		MetricsPath:  "/minio/prometheus/metrics",
+		RetryBackoff: time.Millisecond,
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var requests int32
+	s := flakyServer(2, &requests)
+	defer s.Close()
+
+	p := retryPlugin(t, s)
+	p.Retries = 1
+	if _, err := p.FetchMetrics(); err == nil {
+		t.Fatal("got no error, want the second 503")
+	}
+
+	atomic.StoreInt32(&requests, 0)
+	p.Retries = 2
+	stat, err := p.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat["scrape_success"] != uint64(1) || atomic.LoadInt32(&requests) != 3 {
+		t.Fatalf("got scrape_success=%v after %d requests", stat["scrape_success"], requests)
+	}
+}
+
+func TestRetryWithinTimeout(t *testing.T) {
+	var requests int32
+	s := flakyServer(10, &requests)
+	defer s.Close()
+
+	p := retryPlugin(t, s)
+	p.Retries = 10
+	p.RetryBackoff = time.Second
+	p.Timeout = 100 * time.Millisecond
+	start := time.Now()
+	if _, err := p.FetchMetrics(); err == nil {
+		t.Fatal("got no error, want a 503")
+	}
+	if time.Since(start) > time.Second || atomic.LoadInt32(&requests) != 1 {
+		t.Fatalf("retried %d times beyond the timeout", requests)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var requests int32
+	s := flakyServer(2, &requests)
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "mackerel-plugin-minio-breaker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := retryPlugin(t, s)
+	p.Tempfile = filepath.Join(dir, "mackerel-plugin-minio")
+	p.BreakerThreshold = 2
+	p.BreakerCooldown = time.Hour
+
+	for i := 0; i < 2; i++ {
+		p.FetchMetrics()
+	}
+	stat, err := p.FetchMetrics()
+	if err == nil || stat["breaker_open"] != uint64(1) {
+		t.Fatalf("got breaker_open=%v, want the breaker to be open", stat["breaker_open"])
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Fatalf("got=%d requests, want=2 while the breaker is open", n)
+	}
+
+	// Let the cooldown pass
+	state, _ := loadState(p.stateFile())
+	state.Breaker.OpenUntil = time.Now().Add(-time.Second).Unix()
+	if err := state.save(p.stateFile()); err != nil {
+		t.Fatal(err)
+	}
+	stat, err = p.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat["breaker_open"] != uint64(0) || stat["breaker_consecutive_failures"] != uint64(0) {
+		t.Fatalf("got breaker_open=%v, want the breaker to be closed", stat["breaker_open"])
+	}
+}
+
+func TestCircuitBreakerPartialFailure(t *testing.T) {
+	s := SetupMockServerV3(t)
+	defer s.Server.Close()
+
+	dir, err := ioutil.TempDir("", "mackerel-plugin-minio-breaker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// system/memory is missing, which no retry fixes
+	p := s.plugin
+	p.MetricsGroups = []string{"api/requests", "system/memory"}
+	p.Tempfile = filepath.Join(dir, "mackerel-plugin-minio")
+	p.BreakerThreshold = 1
+	p.BreakerCooldown = time.Hour
+
+	for i := 0; i < 3; i++ {
+		stat, _ := p.FetchMetrics()
+		if stat["scrape_success"] != uint64(0) || stat["breaker_open"] != uint64(0) || stat["breaker_consecutive_failures"] != uint64(0) {
+			t.Fatalf("got scrape_success=%v breaker_open=%v, want a partial scrape keeping the breaker closed", stat["scrape_success"], stat["breaker_open"])
+		}
+	}
+}
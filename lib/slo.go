@@ -0,0 +1,144 @@
+package mpminio
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// apdexToleratingFactor makes the tolerable threshold four times the target unless given, as Apdex defines
+const apdexToleratingFactor = 4
+
+// LatencySLO is the latency target of a request type such as GET.
+// Requests within Target satisfy users, and ones within Tolerable are tolerated.
+type LatencySLO struct {
+	Method    string
+	Target    time.Duration
+	Tolerable time.Duration
+}
+
+// countBelow estimates the number of observations not greater than x by linear interpolation
+// within the bucket it falls in. Above the highest finite bound, the count at the bound is returned.
+func (b bucketCounts) countBelow(x float64) float64 {
+	bounds := b.sorted()
+	i := sort.Search(len(bounds), func(i int) bool { return bounds[i].upper >= x })
+	if i == len(bounds) || math.IsInf(bounds[i].upper, 1) {
+		if i == 0 {
+			return 0
+		}
+		return float64(bounds[i-1].count)
+	}
+
+	var start float64
+	var below uint64
+	if i > 0 {
+		start = bounds[i-1].upper
+		below = bounds[i-1].count
+	}
+	end := bounds[i].upper
+	if x <= start || end <= start {
+		return float64(below)
+	}
+	return float64(below) + float64(bounds[i].count-below)*(x-start)/(end-start)
+}
+
+// total returns the number of observations, reporting false without +Inf
+func (b bucketCounts) total() (uint64, bool) {
+	n, ok := b["+Inf"]
+	return n, ok
+}
+
+// calcSLOs stores the Apdex score and the percentage of requests within the target of each
+// request type having an SLO, observed since the previous run. It returns the number of
+// those requests and the ones within their targets.
+func calcSLOs(stat map[string]interface{}, current, previous histograms, slos []LatencySLO) (float64, float64) {
+	var requests, within float64
+	for rt, h := range current {
+		prev, ok := previous[rt]
+		if !ok {
+			continue
+		}
+		slo, ok := findSLO(slos, rt)
+		if !ok {
+			continue
+		}
+		d := h.delta(prev).Buckets
+		total, ok := d.total()
+		if !ok || total == 0 {
+			continue
+		}
+
+		satisfied := d.countBelow(slo.Target.Seconds())
+		tolerating := d.countBelow(slo.tolerable().Seconds()) - satisfied
+		key := sanitizeKey(rt)
+		stat["http_apdex."+key] = (satisfied + tolerating/2) / float64(total)
+		stat["http_slo_compliance."+key] = satisfied / float64(total) * 100
+		requests += float64(total)
+		within += satisfied
+	}
+	return requests, within
+}
+
+// findSLO returns the SLO of the request type, matching methods case-insensitively
+func findSLO(slos []LatencySLO, requestType string) (LatencySLO, bool) {
+	for _, s := range slos {
+		if strings.EqualFold(s.Method, requestType) {
+			return s, true
+		}
+	}
+	return LatencySLO{}, false
+}
+
+// tolerable returns the tolerable threshold, defaulting to four times the target
+func (s LatencySLO) tolerable() time.Duration {
+	if s.Tolerable > 0 {
+		return s.Tolerable
+	}
+	return s.Target * apdexToleratingFactor
+}
+
+// ParseLatencySLO parses an SLO formatted as "<method>:<target>[,<tolerable>]", e.g. "GET:100ms,400ms"
+func ParseLatencySLO(s string) (LatencySLO, error) {
+	i := strings.Index(s, ":")
+	if i <= 0 {
+		return LatencySLO{}, fmt.Errorf("latency SLO %q lacks a method", s)
+	}
+	slo := LatencySLO{Method: s[:i]}
+
+	thresholds := strings.Split(s[i+1:], ",")
+	if len(thresholds) > 2 {
+		return LatencySLO{}, fmt.Errorf("latency SLO %q has too many thresholds", s)
+	}
+	var err error
+	if slo.Target, err = time.ParseDuration(thresholds[0]); err != nil || slo.Target <= 0 {
+		return LatencySLO{}, fmt.Errorf("latency SLO %q has an invalid target %q", s, thresholds[0])
+	}
+	if len(thresholds) == 2 {
+		if slo.Tolerable, err = time.ParseDuration(thresholds[1]); err != nil || slo.Tolerable < slo.Target {
+			return LatencySLO{}, fmt.Errorf("latency SLO %q has an invalid tolerable threshold %q", s, thresholds[1])
+		}
+	}
+	return slo, nil
+}
+
+// latencySLOsFlag collects latency SLOs given by repeated flags
+type latencySLOsFlag []LatencySLO
+
+func (f *latencySLOsFlag) String() string {
+	slos := make([]string, len(*f))
+	for i, s := range *f {
+		slos[i] = s.Method
+	}
+	return strings.Join(slos, ",")
+}
+
+func (f *latencySLOsFlag) Set(s string) error {
+	slo, err := ParseLatencySLO(s)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, slo)
+	return nil
+}
@@ -0,0 +1,82 @@
+package mpminio
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBucketCountsCountBelow(t *testing.T) {
+	b := bucketCounts{"0.05": 10, "0.1": 20, "0.5": 30, "+Inf": 40}
+
+	tests := []struct {
+		x    float64
+		want float64
+	}{
+		{0.025, 5},
+		{0.075, 15},
+		{0.1, 20},
+		{0.3, 25},
+		// nothing is known above the highest finite bound
+		{1, 30},
+	}
+	for _, tt := range tests {
+		if got := b.countBelow(tt.x); math.Abs(got-tt.want) > 1e-9 {
+			t.Fatalf("x=%v: got=%v, want=%v", tt.x, got, tt.want)
+		}
+	}
+}
+
+func TestCalcSLOs(t *testing.T) {
+	current := histograms{
+		"GET": {Buckets: bucketCounts{"0.05": 10, "0.1": 20, "0.5": 30, "+Inf": 40}},
+		"PUT": {Buckets: bucketCounts{"0.05": 1, "+Inf": 1}},
+	}
+	previous := histograms{
+		"GET": {Buckets: bucketCounts{"0.05": 0, "0.1": 0, "0.5": 0, "+Inf": 0}},
+		"PUT": {Buckets: bucketCounts{"0.05": 0, "+Inf": 0}},
+	}
+	slos := []LatencySLO{{Method: "get", Target: 75 * time.Millisecond, Tolerable: 300 * time.Millisecond}}
+
+	stat := map[string]interface{}{}
+	calcSLOs(stat, current, previous, slos)
+	wants := map[string]float64{
+		// 15 satisfied and 10 tolerating out of 40
+		"http_apdex.GET":          0.5,
+		"http_slo_compliance.GET": 37.5,
+	}
+	for k, want := range wants {
+		if v, ok := stat[k].(float64); !ok || math.Abs(v-want) > 1e-9 {
+			t.Fatalf("%s: got=%v, want=%v", k, stat[k], want)
+		}
+	}
+	if _, ok := stat["http_apdex.PUT"]; ok {
+		t.Fatal("PUT has no SLO")
+	}
+}
+
+func TestParseLatencySLO(t *testing.T) {
+	tests := []struct {
+		in   string
+		want LatencySLO
+		ok   bool
+	}{
+		{"GET:100ms", LatencySLO{Method: "GET", Target: 100 * time.Millisecond}, true},
+		{"PUT:1s,2s", LatencySLO{Method: "PUT", Target: time.Second, Tolerable: 2 * time.Second}, true},
+		{"100ms", LatencySLO{}, false},
+		{"GET:fast", LatencySLO{}, false},
+		{"GET:1s,500ms", LatencySLO{}, false},
+		{"GET:1s,2s,3s", LatencySLO{}, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseLatencySLO(tt.in)
+		if (err == nil) != tt.ok || !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%s: got=%+v/%v, want=%+v", tt.in, got, err, tt.want)
+		}
+	}
+
+	if got := (LatencySLO{Target: 100 * time.Millisecond}).tolerable(); got != 400*time.Millisecond {
+		t.Fatalf("got=%v, want four times the target", got)
+	}
+}
@@ -0,0 +1,78 @@
+package mpminio
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// pluginState is persisted next to the helper's Tempfile between runs.
+// The helper rewrites its own Tempfile on every run, so it cannot be shared.
+type pluginState struct {
+	Breaker breakerState `json:"breaker"`
+	// Histograms are the request durations of the previous run
+	Histograms   histograms `json:"histograms,omitempty"`
+	HistogramsAt int64      `json:"histograms_at,omitempty"`
+	// Budget is the rolling history of requests for error budgets
+	Budget budgetState `json:"budget"`
+}
+
+// stateFile returns the path to the plugin state, or empty when Tempfile is not set
+func (m MinioPlugin) stateFile() string {
+	if m.Tempfile == "" {
+		return ""
+	}
+	return m.Tempfile + ".state"
+}
+
+// loadState reads the state saved by the previous run. A missing file results in an empty state.
+func loadState(path string) (*pluginState, error) {
+	state := &pluginState{}
+	if path == "" {
+		return state, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return state, fmt.Errorf("reading state file failed: %v", err)
+	}
+	if err := json.Unmarshal(b, state); err != nil {
+		// Start over rather than failing every run on a broken file
+		return &pluginState{}, fmt.Errorf("parsing state file failed: %v", err)
+	}
+	return state, nil
+}
+
+// save writes the state atomically so that a killed run never leaves a truncated file
+func (s *pluginState) save(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return fmt.Errorf("saving state file failed: %v", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return fmt.Errorf("saving state file failed: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("saving state file failed: %v", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("saving state file failed: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package mpminio
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+const (
+	// tenantLabel labels the pods of a tenant of MinIO Operator with its name
+	tenantLabel = "v1.min.io/tenant"
+	// tenantPort is the port tenant pods serve the API on
+	tenantPort = 9000
+	// tenantSourcePods and tenantSourceEndpoints are where the servers of a tenant are listed
+	tenantSourcePods      = "pods"
+	tenantSourceEndpoints = "endpoints"
+)
+
+// TenantDiscovery discovers the servers of a tenant of MinIO Operator through Kubernetes API
+type TenantDiscovery struct {
+	// Kubeconfig is the kubeconfig file. Empty means the service account in a cluster, or the default file.
+	Kubeconfig string
+	// Namespace of the tenant. Empty means the one of the context or the service account.
+	Namespace string
+	Tenant    string
+	// Source is "pods", listing the tenant pods, or "endpoints" of its headless service. Empty means pods.
+	Source string
+	// Secret holds the Prometheus bearer token as token, or the root credentials as config.env or
+	// accesskey/secretkey. Empty means the configuration secret of the tenant.
+	Secret string
+}
+
+// tenant is the part of a Tenant resource of MinIO Operator to scrape its servers
+type tenant struct {
+	Spec struct {
+		RequestAutoCert    *bool `json:"requestAutoCert"`
+		ExternalCertSecret []struct {
+			Name string `json:"name"`
+		} `json:"externalCertSecret"`
+		Configuration *struct {
+			Name string `json:"name"`
+		} `json:"configuration"`
+		CredsSecret *struct {
+			Name string `json:"name"`
+		} `json:"credsSecret"`
+	} `json:"spec"`
+}
+
+// tls reports whether the tenant serves TLS, which is the default of MinIO Operator
+func (t tenant) tls() bool {
+	return t.autoCert() || len(t.Spec.ExternalCertSecret) > 0
+}
+
+// autoCert reports whether MinIO Operator has the certificates of the tenant signed by the cluster CA
+func (t tenant) autoCert() bool {
+	return t.Spec.RequestAutoCert == nil || *t.Spec.RequestAutoCert
+}
+
+// secretName returns the secret of the tenant holding its root credentials
+func (t tenant) secretName() string {
+	if t.Spec.Configuration != nil && t.Spec.Configuration.Name != "" {
+		return t.Spec.Configuration.Name
+	}
+	if t.Spec.CredsSecret != nil {
+		return t.Spec.CredsSecret.Name
+	}
+	return ""
+}
+
+// Discover returns a node for each server of the tenant with the other settings of base. Nodes are named
+// by their pods, and verified with the names of the headless service since pods are scraped on their IPs.
+// In a cluster, certificates of the tenant requested from the cluster are verified with its CA unless base
+// has a CA certificate. The credentials of the tenant are used unless base has any.
+func (d TenantDiscovery) Discover(ctx context.Context, base MinioPlugin) ([]MinioPlugin, error) {
+	k, err := newKubeClient(d.Kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	namespace := d.Namespace
+	if namespace == "" {
+		namespace = k.namespace
+	}
+	nsPath := "/namespaces/" + url.PathEscape(namespace)
+
+	var t tenant
+	if err := k.get(ctx, "/apis/minio.min.io/v2"+nsPath+"/tenants/"+url.PathEscape(d.Tenant), &t); err != nil {
+		return nil, err
+	}
+	base.Scheme = "http"
+	if t.tls() {
+		base.Scheme = "https"
+	}
+	// Out of a cluster, the CA is given by -ca-cert
+	if t.autoCert() && base.CACert == "" && !base.Insecure {
+		base.CACert = k.caFile
+	}
+	if base.BearerToken == "" && base.BearerTokenFile == "" && base.AccessKey == "" && base.SecretKey == "" {
+		secret := d.Secret
+		if secret == "" {
+			secret = t.secretName()
+		}
+		if secret != "" {
+			if err := k.tenantCredentials(ctx, "/api/v1"+nsPath+"/secrets/"+url.PathEscape(secret), &base); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// the headless service names each server of the tenant for its certificate
+	domain := d.Tenant + "-hl." + namespace + ".svc.cluster.local"
+	var nodes []MinioPlugin
+	switch d.Source {
+	case "", tenantSourcePods:
+		nodes, err = k.tenantPods(ctx, "/api/v1"+nsPath+"/pods", d.Tenant, domain, base)
+	case tenantSourceEndpoints:
+		nodes, err = k.tenantEndpoints(ctx, "/api/v1"+nsPath+"/endpoints/"+url.PathEscape(d.Tenant+"-hl"), domain, base)
+	default:
+		err = fmt.Errorf("unknown tenant source: %s", d.Source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no running server of tenant %s/%s is found", namespace, d.Tenant)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodeID(nodes[i]) < nodeID(nodes[j]) })
+	return nodes, nil
+}
+
+// tenantCredentials reads the bearer token or the root credentials from the secret into m
+func (k *kubeClient) tenantCredentials(ctx context.Context, path string, m *MinioPlugin) error {
+	var secret struct {
+		Data map[string]string `json:"data"`
+	}
+	if err := k.get(ctx, path, &secret); err != nil {
+		return err
+	}
+	data := map[string]string{}
+	for key, v := range secret.Data {
+		b, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return fmt.Errorf("decoding %s of the tenant secret failed: %v", key, err)
+		}
+		data[key] = string(b)
+	}
+
+	switch {
+	case data["token"] != "":
+		m.BearerToken = data["token"]
+	case data["config.env"] != "":
+		env, err := parseMinioEnv(bytes.NewBufferString(data["config.env"]), "config.env")
+		if err != nil {
+			return err
+		}
+		m.AccessKey, m.SecretKey = env.AccessKey, env.SecretKey
+	default:
+		m.AccessKey, m.SecretKey = data["accesskey"], data["secretkey"]
+	}
+	return nil
+}
+
+// tenantPods returns a node for each running pod of the tenant
+func (k *kubeClient) tenantPods(ctx context.Context, path, tenant, domain string, base MinioPlugin) ([]MinioPlugin, error) {
+	var pods struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+			Spec struct {
+				Hostname string `json:"hostname"`
+			} `json:"spec"`
+			Status struct {
+				Phase string `json:"phase"`
+				PodIP string `json:"podIP"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	selector := url.QueryEscape(tenantLabel + "=" + tenant)
+	if err := k.get(ctx, path+"?labelSelector="+selector, &pods); err != nil {
+		return nil, err
+	}
+
+	nodes := []MinioPlugin{}
+	for _, p := range pods.Items {
+		if p.Status.Phase != "Running" || p.Status.PodIP == "" {
+			continue
+		}
+		nodes = append(nodes, tenantNode(base, p.Metadata.Name, p.Status.PodIP, strconv.Itoa(tenantPort), p.Spec.Hostname, domain))
+	}
+	return nodes, nil
+}
+
+// tenantEndpoints returns a node for each ready address of the headless service of the tenant
+func (k *kubeClient) tenantEndpoints(ctx context.Context, path, domain string, base MinioPlugin) ([]MinioPlugin, error) {
+	var endpoints struct {
+		Subsets []struct {
+			Addresses []struct {
+				IP        string `json:"ip"`
+				Hostname  string `json:"hostname"`
+				TargetRef *struct {
+					Name string `json:"name"`
+				} `json:"targetRef"`
+			} `json:"addresses"`
+			Ports []struct {
+				Name string `json:"name"`
+				Port int    `json:"port"`
+			} `json:"ports"`
+		} `json:"subsets"`
+	}
+	if err := k.get(ctx, path, &endpoints); err != nil {
+		return nil, err
+	}
+
+	nodes := []MinioPlugin{}
+	for _, s := range endpoints.Subsets {
+		port := tenantPort
+		for _, p := range s.Ports {
+			if p.Name == "http-minio" || p.Name == "https-minio" {
+				port = p.Port
+			}
+		}
+		for _, a := range s.Addresses {
+			name := a.Hostname
+			if a.TargetRef != nil && a.TargetRef.Name != "" {
+				name = a.TargetRef.Name
+			}
+			if name == "" {
+				name = a.IP
+			}
+			nodes = append(nodes, tenantNode(base, name, a.IP, strconv.Itoa(port), a.Hostname, domain))
+		}
+	}
+	return nodes, nil
+}
+
+// tenantNode returns the node of a server of the tenant
+func tenantNode(base MinioPlugin, name, ip, port, hostname, domain string) MinioPlugin {
+	node := base
+	node.Name = name
+	node.Host = ip
+	node.Port = port
+	if node.ServerName == "" && hostname != "" {
+		node.ServerName = hostname + "." + domain
+	}
+	return node
+}
@@ -0,0 +1,109 @@
+package mpminio
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// kubeAPIServer serves a fake Kubernetes API with the tenant "hot" in the namespace "minio-tenant"
+func kubeAPIServer() *httptest.Server {
+	b64 := base64.StdEncoding.EncodeToString
+	responses := map[string]string{
+		"/apis/minio.min.io/v2/namespaces/minio-tenant/tenants/hot": `{"spec": {"configuration": {"name": "hot-env-configuration"}}}`,
+		"/api/v1/namespaces/minio-tenant/secrets/hot-env-configuration": fmt.Sprintf(`{"data": {"config.env": %q}}`,
+			b64([]byte("export MINIO_ROOT_USER=\"root\"\nexport MINIO_ROOT_PASSWORD=\"root-secret\"\n"))),
+		"/api/v1/namespaces/minio-tenant/secrets/hot-prometheus": fmt.Sprintf(`{"data": {"token": %q}}`, b64([]byte("prometheus-token"))),
+		"/api/v1/namespaces/minio-tenant/pods": `{"items": [
+			{"metadata": {"name": "hot-pool-0-1"}, "spec": {"hostname": "hot-pool-0-1"}, "status": {"phase": "Running", "podIP": "10.0.0.2"}},
+			{"metadata": {"name": "hot-pool-0-0"}, "spec": {"hostname": "hot-pool-0-0"}, "status": {"phase": "Running", "podIP": "10.0.0.1"}},
+			{"metadata": {"name": "hot-pool-0-2"}, "spec": {"hostname": "hot-pool-0-2"}, "status": {"phase": "Pending"}}
+		]}`,
+		"/api/v1/namespaces/minio-tenant/endpoints/hot-hl": `{"subsets": [{
+			"addresses": [{"ip": "10.0.0.1", "hostname": "hot-pool-0-0", "targetRef": {"kind": "Pod", "name": "hot-pool-0-0"}}],
+			"notReadyAddresses": [{"ip": "10.0.0.2", "hostname": "hot-pool-0-1"}],
+			"ports": [{"name": "https-minio", "port": 9443}]
+		}]}`,
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer kube-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/api/v1/namespaces/minio-tenant/pods" && r.URL.Query().Get("labelSelector") != "v1.min.io/tenant=hot" {
+			w.Write([]byte(`{"items": []}`))
+			return
+		}
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+}
+
+func TestTenantDiscovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mackerel-plugin-minio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := kubeAPIServer()
+	defer s.Close()
+	kubeconfig := writeKubeconfig(t, dir, s.URL)
+
+	base := MinioPlugin{Scheme: "http", Port: "9000", Prefix: "hot"}
+	nodes, err := TenantDiscovery{Kubeconfig: kubeconfig, Tenant: "hot"}.Discover(context.Background(), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, n := range nodes {
+		u := n.endpoint("")
+		got[nodeID(n)] = u.String() + " " + n.ServerName + " " + n.AccessKey + "/" + n.SecretKey
+	}
+	want := map[string]string{
+		"hot-pool-0-0": "https://10.0.0.1:9000 hot-pool-0-0.hot-hl.minio-tenant.svc.cluster.local root/root-secret",
+		"hot-pool-0-1": "https://10.0.0.2:9000 hot-pool-0-1.hot-hl.minio-tenant.svc.cluster.local root/root-secret",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got=%v, want=%v", got, want)
+	}
+
+	// the ready addresses of the headless service with the bearer token of a secret
+	d := TenantDiscovery{Kubeconfig: kubeconfig, Namespace: "minio-tenant", Tenant: "hot", Source: tenantSourceEndpoints, Secret: "hot-prometheus"}
+	nodes, err = d.Discover(context.Background(), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := nodes[0].endpoint("")
+	if len(nodes) != 1 || nodeID(nodes[0]) != "hot-pool-0-0" || u.String() != "https://10.0.0.1:9443" || nodes[0].BearerToken != "prometheus-token" {
+		t.Fatalf("got=%+v, want the ready server with the token", nodes)
+	}
+
+	// credentials and the CA given take precedence
+	base.AccessKey, base.SecretKey, base.CACert = "given", "given-secret", "/etc/minio/ca.crt"
+	nodes, err = TenantDiscovery{Kubeconfig: kubeconfig, Tenant: "hot"}.Discover(context.Background(), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nodes[0].AccessKey != "given" || nodes[0].CACert != "/etc/minio/ca.crt" {
+		t.Fatalf("got=%s %s, want the given access key and CA", nodes[0].AccessKey, nodes[0].CACert)
+	}
+
+	for _, d := range []TenantDiscovery{
+		{Kubeconfig: kubeconfig, Tenant: "cold"},
+		{Kubeconfig: kubeconfig, Tenant: "hot", Source: "services"},
+	} {
+		if _, err := d.Discover(context.Background(), base); err == nil {
+			t.Fatalf("%+v: want an error", d)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package mpminio
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// tlsConfig builds the TLS configuration to verify Minio server and optionally
+// to authenticate the plugin with a client certificate
+func (m MinioPlugin) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         m.ServerName,
+		InsecureSkipVerify: m.Insecure,
+	}
+
+	if m.CACert != "" {
+		b, err := ioutil.ReadFile(m.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA certificate failed: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no valid certificate found in %s", m.CACert)
+		}
+		config.RootCAs = pool
+	}
+
+	if m.ClientCert != "" || m.ClientKey != "" {
+		if m.ClientCert == "" || m.ClientKey == "" {
+			return nil, fmt.Errorf("both client certificate and key are required for mutual TLS")
+		}
+		cert, err := tls.LoadX509KeyPair(m.ClientCert, m.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate failed: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
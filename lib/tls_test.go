@@ -0,0 +1,154 @@
+package mpminio
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTLSServer(t *testing.T) *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, metrics)
+	}))
+}
+
+// tlsPlugin returns the plugin pointing to the given test server
+func tlsPlugin(t *testing.T, s *httptest.Server) MinioPlugin {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return MinioPlugin{
+		Scheme:      "https",
+		Host:        u.Hostname(),
+		Port:        u.Port(),
+		MetricsPath: "/minio/prometheus/metrics",
+	}
+}
+
+// writePEM writes a PEM block into dir and returns its path
+func writePEM(t *testing.T, dir, name, typ string, b []byte) string {
+	path := filepath.Join(dir, name)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := pem.Encode(f, &pem.Block{Type: typ, Bytes: b}); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeClientCert generates a self-signed client certificate and returns the paths to it and its key
+func writeClientCert(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "mackerel-plugin-minio"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, writePEM(t, dir, "client.crt", "CERTIFICATE", der), writePEM(t, dir, "client.key", "EC PRIVATE KEY", keyDER)
+}
+
+func TestTLSVerification(t *testing.T) {
+	s := newTLSServer(t)
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "mackerel-plugin-minio-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caCert := writePEM(t, dir, "ca.crt", "CERTIFICATE", s.Certificate().Raw)
+
+	tests := []struct {
+		name    string
+		setup   func(*MinioPlugin)
+		wantErr bool
+	}{
+		{"unknown authority", func(p *MinioPlugin) {}, true},
+		{"insecure", func(p *MinioPlugin) { p.Insecure = true }, false},
+		{"ca cert", func(p *MinioPlugin) { p.CACert = caCert }, false},
+		{"server name", func(p *MinioPlugin) { p.CACert = caCert; p.ServerName = "example.com" }, false},
+		{"server name mismatch", func(p *MinioPlugin) { p.CACert = caCert; p.ServerName = "minio.invalid" }, true},
+	}
+	for _, tt := range tests {
+		p := tlsPlugin(t, s)
+		tt.setup(&p)
+		_, err := p.FetchMetrics()
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%s: got=%v, wantErr=%v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mackerel-plugin-minio-mtls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cert, clientCert, clientKey := writeClientCert(t, dir)
+
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, metrics)
+	}))
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	s.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	s.StartTLS()
+	defer s.Close()
+
+	p := tlsPlugin(t, s)
+	p.Insecure = true
+	if _, err := p.FetchMetrics(); err == nil {
+		t.Fatal("handshake without a client certificate succeeded")
+	}
+
+	p.ClientCert = clientCert
+	p.ClientKey = clientKey
+	if _, err := p.FetchMetrics(); err != nil {
+		t.Fatal(err)
+	}
+
+	p.ClientKey = ""
+	if _, err := p.FetchMetrics(); err == nil {
+		t.Fatal("client certificate without a key is accepted")
+	}
+}
@@ -0,0 +1,219 @@
+package mpminio
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+	"github.com/prometheus/prom2json"
+)
+
+// Metrics v2 endpoints.
+// FYI, see https://min.io/docs/minio/linux/operations/monitoring/collect-minio-metrics-using-prometheus.html
+const (
+	metricsPathV2Node    = "/minio/v2/metrics/node"
+	metricsPathV2Cluster = "/minio/v2/metrics/cluster"
+	metricsPathV2Bucket  = "/minio/v2/metrics/bucket"
+)
+
+// metricsPathsV2 is ordered so that node local families take precedence
+// over the same families aggregated from every peer on the cluster endpoint.
+var metricsPathsV2 = []string{
+	metricsPathV2Node,
+	metricsPathV2Cluster,
+	metricsPathV2Bucket,
+}
+
+// fetchMetricsV2 scrapes all of the v2 endpoints and merges them into one Stat.
+// A failing endpoint is reported without discarding the others.
+func (m MinioPlugin) fetchMetricsV2(ctx context.Context) (map[string]interface{}, error) {
+	stat := make(Stat)
+	seen := map[string]bool{}
+	fl := m.flattener()
+	var errs, convErrs errorList
+	for _, path := range metricsPathsV2 {
+		if err := ctx.Err(); err != nil {
+			errs.add(fmt.Errorf("scraping %s is aborted: %v", path, err))
+			continue
+		}
+		families, err := m.fetchFamilies(ctx, path)
+		if err != nil {
+			// The bucket endpoint is missing on older releases
+			if !(isNotFound(err) && path == metricsPathV2Bucket) {
+				errs.add(err)
+			}
+			continue
+		}
+
+		for _, f := range families {
+			if seen[f.Name] {
+				continue
+			}
+			seen[f.Name] = true
+			convErrs.add(stat.handleLabeled(f, fl))
+		}
+	}
+	// Values failing to convert are reported without failing the scrape
+	setScrapeSuccess(stat, len(errs) == 0)
+	errs.add(convErrs.err())
+	errs.add(calcMetricsV2(stat))
+
+	return stat, errs.err()
+}
+
+// handleLabeled maps series of v2 and v3 families, whose labels are far richer than v1 ones.
+// Values failing to convert are skipped and reported.
+func (s *Stat) handleLabeled(family *prom2json.Family, fl *flattener) error {
+	var errs errorList
+	for _, item := range family.Metrics {
+		if m, ok := item.(prom2json.Summary); ok {
+			errs.add(s.handleSummary(family.Name, m, fl))
+			continue
+		}
+		m, ok := item.(prom2json.Metric)
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseFloat(m.Value, 64)
+		if err != nil {
+			errs.add(fmt.Errorf("failed to convert %s value of %s", m.Value, family.Name))
+			continue
+		}
+
+		// Series sharing a key, e.g. peers on the cluster endpoint or drives of a node, are aggregated
+		key, how := fl.key(family.Name, m.Labels)
+		s.aggregate(key, value, how)
+	}
+	return errs.err()
+}
+
+// calcMetricsV2 appends manually calculated metrics. Metrics missing their inputs are skipped and reported.
+func calcMetricsV2(stat map[string]interface{}) error {
+	// Drives are kept apart for their wildcard graph, so the node totals are their sum
+	for _, k := range []string{"total_bytes", "used_bytes", "free_bytes"} {
+		sumSeries(stat, "minio_node_drive_"+k, "node.drives.", "."+k)
+	}
+
+	var errs errorList
+	errs.add(derivePercentage(stat, "minio_node_drive_used_percent", "minio_node_drive_used_bytes", "minio_node_drive_total_bytes"))
+	errs.add(derivePercentage(stat, "minio_node_file_descriptor_used_percent", "minio_node_file_descriptor_open_total", "minio_node_file_descriptor_limit_total"))
+	errs.add(deriveUsedPercentage(stat, "minio_cluster_capacity_usable_used_percent", "minio_cluster_capacity_usable_free_bytes", "minio_cluster_capacity_usable_total_bytes"))
+	return errs.err()
+}
+
+func (m MinioPlugin) graphDefinitionV2() map[string]mp.Graphs {
+	labelPrefix := strings.Title(m.Prefix)
+
+	return map[string]mp.Graphs{
+		"capacity": {
+			Label: (labelPrefix + " Cluster Capacity"),
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "minio_cluster_capacity_usable_total_bytes", Label: "Usable Total"},
+				{Name: "minio_cluster_capacity_usable_free_bytes", Label: "Usable Free"},
+				{Name: "minio_cluster_capacity_raw_total_bytes", Label: "Raw Total"},
+				{Name: "minio_cluster_capacity_raw_free_bytes", Label: "Raw Free"},
+			},
+		},
+		"capacity_usage": {
+			Label: (labelPrefix + " Cluster Capacity Usage Percentage"),
+			Unit:  "percentage",
+			Metrics: []mp.Metrics{
+				{Name: "minio_cluster_capacity_usable_used_percent", Label: "Used"},
+			},
+		},
+		"nodes": {
+			Label: (labelPrefix + " Cluster Nodes"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "minio_cluster_nodes_online_total", Label: "Online"},
+				{Name: "minio_cluster_nodes_offline_total", Label: "Offline"},
+			},
+		},
+		"drives": {
+			Label: (labelPrefix + " Cluster Drives"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "minio_cluster_drive_online_total", Label: "Online"},
+				{Name: "minio_cluster_drive_offline_total", Label: "Offline"},
+			},
+		},
+		"node.drive": {
+			Label: (labelPrefix + " Node Drive"),
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "minio_node_drive_total_bytes", Label: "Total"},
+				{Name: "minio_node_drive_used_bytes", Label: "Used"},
+				{Name: "minio_node_drive_free_bytes", Label: "Free"},
+			},
+		},
+		"node.drives.#": {
+			Label: (labelPrefix + " Node Drives"),
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "total_bytes", Label: "Total"},
+				{Name: "used_bytes", Label: "Used"},
+				{Name: "free_bytes", Label: "Free"},
+			},
+		},
+		"node.drive_usage": {
+			Label: (labelPrefix + " Node Drive Usage Percentage"),
+			Unit:  "percentage",
+			Metrics: []mp.Metrics{
+				{Name: "minio_node_drive_used_percent", Label: "Used"},
+			},
+		},
+		"node.fds": {
+			Label: (labelPrefix + " Node FDs Percentage"),
+			Unit:  "percentage",
+			Metrics: []mp.Metrics{
+				{Name: "minio_node_file_descriptor_used_percent", Label: "Consumed"},
+			},
+		},
+		"node.goroutines": {
+			Label: (labelPrefix + " Node Goroutines"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "minio_node_go_routine_total", Label: "Goroutines"},
+			},
+		},
+		"s3.requests": {
+			Label: (labelPrefix + " S3 Requests"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "*", Label: "%1", Diff: true, Stacked: true},
+			},
+		},
+		"s3.errors": {
+			Label: (labelPrefix + " S3 Request Errors"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "*", Label: "%1", Diff: true, Stacked: true},
+			},
+		},
+		"s3.traffic": {
+			Label: (labelPrefix + " S3 Traffic"),
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "minio_s3_traffic_received_bytes", Label: "Received", Diff: true},
+				{Name: "minio_s3_traffic_sent_bytes", Label: "Sent", Diff: true},
+			},
+		},
+		"bucket_size.#": {
+			Label: (labelPrefix + " Bucket Size"),
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "total_bytes", Label: "Total"},
+			},
+		},
+		"bucket_objects.#": {
+			Label: (labelPrefix + " Bucket Objects"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "objects", Label: "Objects"},
+			},
+		},
+	}
+}
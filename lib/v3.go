@@ -0,0 +1,237 @@
+package mpminio
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+)
+
+// metricsPathV3 is the root of the v3 metrics hierarchy.
+// FYI, see https://min.io/docs/minio/linux/operations/monitoring/metrics-and-alerts.html
+const metricsPathV3 = "/minio/metrics/v3"
+
+// defaultMetricsGroupsV3 are scraped unless groups are given explicitly
+var defaultMetricsGroupsV3 = []string{
+	"api/requests",
+	"system/drive",
+	"system/memory",
+	"cluster/health",
+}
+
+// fetchMetricsV3 scrapes every configured group and merges them into one Stat.
+// A failing group is reported without discarding the others.
+func (m MinioPlugin) fetchMetricsV3(ctx context.Context) (map[string]interface{}, error) {
+	stat := make(Stat)
+	fl := m.flattener()
+	var errs, convErrs errorList
+	for _, group := range m.metricsGroups() {
+		if err := ctx.Err(); err != nil {
+			errs.add(fmt.Errorf("scraping %s is aborted: %v", group, err))
+			continue
+		}
+		families, err := m.fetchFamilies(ctx, metricsPathV3+"/"+group)
+		if err != nil {
+			errs.add(err)
+			continue
+		}
+		for _, f := range families {
+			convErrs.add(stat.handleLabeled(f, fl))
+		}
+	}
+	// Values failing to convert are reported without failing the scrape
+	setScrapeSuccess(stat, len(errs) == 0)
+	errs.add(convErrs.err())
+	errs.add(calcMetricsV3(stat, m.metricsGroups()))
+
+	return stat, errs.err()
+}
+
+// metricsGroups returns the v3 groups to scrape without surrounding slashes
+func (m MinioPlugin) metricsGroups() []string {
+	if len(m.MetricsGroups) == 0 {
+		return defaultMetricsGroupsV3
+	}
+
+	groups := []string{}
+	for _, g := range m.MetricsGroups {
+		if g = strings.Trim(g, "/"); g != "" {
+			groups = append(groups, g)
+		}
+	}
+	return groups
+}
+
+// calcMetricsV3 appends manually calculated metrics of the scraped groups.
+// Metrics missing their inputs are skipped and reported.
+func calcMetricsV3(stat map[string]interface{}, groups []string) error {
+	var errs errorList
+	for _, group := range groups {
+		switch group {
+		case "system/drive":
+			// Drives are kept apart for their wildcard graph, so the totals are their sum
+			for _, k := range []string{"total_bytes", "used_bytes", "free_bytes"} {
+				sumSeries(stat, "minio_system_drive_"+k, "system_drive.drives.", "."+k)
+			}
+			errs.add(derivePercentage(stat, "minio_system_drive_used_percent", "minio_system_drive_used_bytes", "minio_system_drive_total_bytes"))
+		case "cluster/health":
+			errs.add(deriveUsedPercentage(stat, "minio_cluster_health_capacity_usable_used_percent", "minio_cluster_health_capacity_usable_free_bytes", "minio_cluster_health_capacity_usable_total_bytes"))
+		}
+	}
+	return errs.err()
+}
+
+func (m MinioPlugin) graphDefinitionV3() map[string]mp.Graphs {
+	labelPrefix := strings.Title(m.Prefix)
+	groups := graphDefinitionV3Groups(labelPrefix)
+
+	graphs := map[string]mp.Graphs{}
+	for _, group := range m.metricsGroups() {
+		// Sub paths such as bucket/replication/<bucket> share the graphs of their group
+		for base, defs := range groups {
+			if group != base && !strings.HasPrefix(group, base+"/") {
+				continue
+			}
+			for k, g := range defs {
+				graphs[k] = g
+			}
+		}
+	}
+	return graphs
+}
+
+// graphDefinitionV3Groups returns graph definitions keyed by the v3 group emitting them
+func graphDefinitionV3Groups(labelPrefix string) map[string]map[string]mp.Graphs {
+	return map[string]map[string]mp.Graphs{
+		"api/requests": {
+			"api_requests.count": {
+				Label: (labelPrefix + " API Requests"),
+				Unit:  "integer",
+				Metrics: []mp.Metrics{
+					{Name: "*", Label: "%1", Diff: true, Stacked: true},
+				},
+			},
+			"api_requests.errors": {
+				Label: (labelPrefix + " API Request Errors"),
+				Unit:  "integer",
+				Metrics: []mp.Metrics{
+					{Name: "*", Label: "%1", Diff: true, Stacked: true},
+				},
+			},
+			"api_requests.inflight": {
+				Label: (labelPrefix + " API Inflight Requests"),
+				Unit:  "integer",
+				Metrics: []mp.Metrics{
+					{Name: "minio_api_requests_inflight_total", Label: "In Flight"},
+				},
+			},
+			"api_requests.traffic": {
+				Label: (labelPrefix + " API Traffic"),
+				Unit:  "bytes",
+				Metrics: []mp.Metrics{
+					{Name: "minio_api_requests_traffic_received_bytes", Label: "Received", Diff: true},
+					{Name: "minio_api_requests_traffic_sent_bytes", Label: "Sent", Diff: true},
+				},
+			},
+		},
+		"system/drive": {
+			"system_drive.usage": {
+				Label: (labelPrefix + " Drive Usage"),
+				Unit:  "bytes",
+				Metrics: []mp.Metrics{
+					{Name: "minio_system_drive_total_bytes", Label: "Total"},
+					{Name: "minio_system_drive_used_bytes", Label: "Used"},
+					{Name: "minio_system_drive_free_bytes", Label: "Free"},
+				},
+			},
+			"system_drive.usage_percent": {
+				Label: (labelPrefix + " Drive Usage Percentage"),
+				Unit:  "percentage",
+				Metrics: []mp.Metrics{
+					{Name: "minio_system_drive_used_percent", Label: "Used"},
+				},
+			},
+			"system_drive.count": {
+				Label: (labelPrefix + " Drive Counts"),
+				Unit:  "integer",
+				Metrics: []mp.Metrics{
+					{Name: "minio_system_drive_online_count", Label: "Online"},
+					{Name: "minio_system_drive_offline_count", Label: "Offline"},
+				},
+			},
+		},
+		"system/memory": {
+			"system_memory.usage": {
+				Label: (labelPrefix + " System Memory"),
+				Unit:  "bytes",
+				Metrics: []mp.Metrics{
+					{Name: "minio_system_memory_total", Label: "Total"},
+					{Name: "minio_system_memory_used", Label: "Used"},
+					{Name: "minio_system_memory_free", Label: "Free"},
+					{Name: "minio_system_memory_cache", Label: "Cache"},
+				},
+			},
+			"system_memory.usage_percent": {
+				Label: (labelPrefix + " System Memory Usage Percentage"),
+				Unit:  "percentage",
+				Metrics: []mp.Metrics{
+					{Name: "minio_system_memory_used_perc", Label: "Used"},
+				},
+			},
+		},
+		"cluster/health": {
+			"cluster_health.drives": {
+				Label: (labelPrefix + " Cluster Health Drives"),
+				Unit:  "integer",
+				Metrics: []mp.Metrics{
+					{Name: "minio_cluster_health_drives_online_count", Label: "Online"},
+					{Name: "minio_cluster_health_drives_offline_count", Label: "Offline"},
+				},
+			},
+			"cluster_health.nodes": {
+				Label: (labelPrefix + " Cluster Health Nodes"),
+				Unit:  "integer",
+				Metrics: []mp.Metrics{
+					{Name: "minio_cluster_health_nodes_online_count", Label: "Online"},
+					{Name: "minio_cluster_health_nodes_offline_count", Label: "Offline"},
+				},
+			},
+			"cluster_health.capacity": {
+				Label: (labelPrefix + " Cluster Health Capacity"),
+				Unit:  "bytes",
+				Metrics: []mp.Metrics{
+					{Name: "minio_cluster_health_capacity_usable_total_bytes", Label: "Usable Total"},
+					{Name: "minio_cluster_health_capacity_usable_free_bytes", Label: "Usable Free"},
+				},
+			},
+			"cluster_health.capacity_usage": {
+				Label: (labelPrefix + " Cluster Health Capacity Usage Percentage"),
+				Unit:  "percentage",
+				Metrics: []mp.Metrics{
+					{Name: "minio_cluster_health_capacity_usable_used_percent", Label: "Used"},
+				},
+			},
+		},
+		"bucket/replication": {
+			"bucket_replication_bytes.#": {
+				Label: (labelPrefix + " Bucket Replication Bytes"),
+				Unit:  "bytes",
+				Metrics: []mp.Metrics{
+					{Name: "sent", Label: "Sent"},
+					{Name: "received", Label: "Received"},
+					{Name: "last_hour_failed", Label: "Failed (last hour)"},
+					{Name: "total_failed", Label: "Failed (total)"},
+				},
+			},
+			"bucket_replication_count.#": {
+				Label: (labelPrefix + " Bucket Replication Failures"),
+				Unit:  "integer",
+				Metrics: []mp.Metrics{
+					{Name: "last_hour_failed", Label: "Failed (last hour)"},
+					{Name: "total_failed", Label: "Failed (total)"},
+				},
+			},
+		},
+	}
+}